@@ -0,0 +1,429 @@
+// Package envelope implements SM2/SM4 digital envelopes in the style of
+// GM/T 0010: the payload is encrypted with a random SM4 key, and that key is
+// encrypted to the recipient's SM2 public key.
+package envelope
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"envelope/sm2"
+	"envelope/sm3"
+	"envelope/sm4"
+	"envelope/x509"
+	"errors"
+	"io"
+	"math/big"
+)
+
+var (
+	oidSM2Data          = asn1.ObjectIdentifier{1, 2, 156, 10197, 6, 1, 4, 2, 1}
+	oidSM2EnvelopedData = asn1.ObjectIdentifier{1, 2, 156, 10197, 6, 1, 4, 2, 3}
+	oidSM2Encryption    = asn1.ObjectIdentifier{1, 2, 156, 10197, 1, 301, 3}
+	oidSM4CBC           = asn1.ObjectIdentifier{1, 2, 156, 10197, 1, 104}
+)
+
+const (
+	envelopeVersion      = 1
+	envelopeVersionMulti = 2 // SealMulti: RecipientInfos为SET
+)
+
+// ErrUnsupportedEnvelopeVersion is returned by Open and NewOpenReader for
+// envelopes written in a format version this package does not know.
+var ErrUnsupportedEnvelopeVersion = errors.New("envelope: unsupported envelope version")
+
+// ErrDecryptionFailed is returned by Open when the content does not decrypt
+// under the recovered key. Bad CBC padding is reported with this same error
+// so that it cannot be told apart from any other decryption failure.
+var ErrDecryptionFailed = errors.New("envelope: content decryption failed")
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type envelopedData struct {
+	Version              int
+	RecipientInfo        recipientInfo
+	EncryptedContentInfo encryptedContentInfo
+}
+
+// envelopedDataMulti is the version 2 layout written by SealMulti.
+type envelopedDataMulti struct {
+	Version              int
+	RecipientInfos       []recipientInfo `asn1:"set"`
+	EncryptedContentInfo encryptedContentInfo
+}
+
+type recipientInfo struct {
+	Version                int
+	IssuerAndSerialNumber  issuerAndSerial
+	SubjectKeyID           []byte `asn1:"optional,tag:0"` // SealMulti: recipientKeyID
+	KeyEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedKey           []byte
+}
+
+type issuerAndSerial struct {
+	IssuerName   asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type encryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           []byte `asn1:"tag:0,optional"`
+}
+
+// recipientPublicKey returns the SM2 public key of recipientCert.
+func recipientPublicKey(recipientCert *x509.Certificate) (*sm2.PublicKey, error) {
+	if recipientCert == nil {
+		return nil, errors.New("envelope: missing recipient certificate")
+	}
+	pub, ok := recipientCert.PublicKey.(*sm2.PublicKey)
+	if !ok {
+		return nil, errors.New("envelope: recipient certificate does not hold an SM2 public key")
+	}
+	return pub, nil
+}
+
+// recipientPublicKeys returns the SM2 public keys of a non-empty recipient
+// list.
+func recipientPublicKeys(recipients []*x509.Certificate) ([]*sm2.PublicKey, error) {
+	if len(recipients) == 0 {
+		return nil, errors.New("envelope: no recipients")
+	}
+	pubs := make([]*sm2.PublicKey, len(recipients))
+	for i, cert := range recipients {
+		pub, err := recipientPublicKey(cert)
+		if err != nil {
+			return nil, err
+		}
+		pubs[i] = pub
+	}
+	return pubs, nil
+}
+
+// recipientKeyID returns SM3 over the uncompressed public point. It is used
+// rather than the certificate's subject key identifier extension because Open
+// only has the private key and must be able to recompute it.
+func recipientKeyID(pub *sm2.PublicKey) []byte {
+	return sm3.Sm3Sum(pub.Marshal())
+}
+
+// newRecipientInfo encrypts key to pub and identifies the recipient by the
+// issuer and serial number of recipientCert. With nil opts the key is stored
+// as a GM/T 0009 ASN.1 ciphertext; otherwise it is a raw ciphertext in
+// opts.CipherMode, recorded in the algorithm parameters, drawn from opts.Rand.
+func newRecipientInfo(recipientCert *x509.Certificate, pub *sm2.PublicKey, key []byte, opts *SealOptions) (recipientInfo, error) {
+	ri := recipientInfo{
+		Version: 1,
+		IssuerAndSerialNumber: issuerAndSerial{
+			IssuerName:   asn1.RawValue{FullBytes: recipientCert.RawIssuer},
+			SerialNumber: recipientCert.SerialNumber,
+		},
+		KeyEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidSM2Encryption},
+	}
+	var err error
+	if opts == nil {
+		ri.EncryptedKey, err = sm2.Encrypt(rand.Reader, pub, key)
+		return ri, err
+	}
+	random := opts.Rand
+	if random == nil {
+		random = rand.Reader
+	}
+	modeParam, err := asn1.Marshal(int(opts.CipherMode))
+	if err != nil {
+		return recipientInfo{}, err
+	}
+	ri.KeyEncryptionAlgorithm.Parameters = asn1.RawValue{FullBytes: modeParam}
+	ri.EncryptedKey, err = pub.EncryptWithMode(random, key, opts.CipherMode)
+	return ri, err
+}
+
+// decryptKey recovers the content encryption key with priv.
+func (ri *recipientInfo) decryptKey(priv *sm2.PrivateKey) ([]byte, error) {
+	if !ri.KeyEncryptionAlgorithm.Algorithm.Equal(oidSM2Encryption) {
+		return nil, errors.New("envelope: unsupported key encryption algorithm")
+	}
+	// 带参数时EncryptedKey为按参数指定顺序排列的原始密文，见SealWithOptions
+	if len(ri.KeyEncryptionAlgorithm.Parameters.FullBytes) != 0 {
+		var mode int
+		if _, err := asn1.Unmarshal(ri.KeyEncryptionAlgorithm.Parameters.FullBytes, &mode); err != nil {
+			return nil, err
+		}
+		return priv.DecryptWithMode(ri.EncryptedKey, sm2.CiphertextMode(mode))
+	}
+	return sm2.Decrypt(priv, ri.EncryptedKey)
+}
+
+// Seal encrypts plaintext for the holder of recipientCert's SM2 private key
+// and returns the DER encoded envelope. The content is encrypted with
+// SM4-CBC, which does not detect tampering; SealWithOptions seals with
+// SM4-GCM by default.
+func Seal(recipientCert *x509.Certificate, plaintext []byte) ([]byte, error) {
+	pub, err := recipientPublicKey(recipientCert)
+	if err != nil {
+		return nil, err
+	}
+	key, eci, err := encryptContent(rand.Reader, plaintext)
+	if err != nil {
+		return nil, err
+	}
+	defer zero(key)
+	ri, err := newRecipientInfo(recipientCert, pub, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	return marshalEnvelope(envelopedData{
+		Version:              envelopeVersion,
+		RecipientInfo:        ri,
+		EncryptedContentInfo: eci,
+	})
+}
+
+// SealMulti encrypts plaintext once under a random SM4 key and encrypts that
+// key to each recipient, so that any one of them can Open the envelope. Each
+// recipient info is tagged with the recipient's key ID so Open can go straight
+// to the right one.
+func SealMulti(recipients []*x509.Certificate, plaintext []byte) ([]byte, error) {
+	pubs, err := recipientPublicKeys(recipients)
+	if err != nil {
+		return nil, err
+	}
+	key, eci, err := encryptContent(rand.Reader, plaintext)
+	if err != nil {
+		return nil, err
+	}
+	defer zero(key)
+	return sealMultiKey(recipients, pubs, key, eci)
+}
+
+// sealMultiKey encrypts key to every recipient and writes a version 2
+// envelope around the already encrypted content eci.
+func sealMultiKey(recipients []*x509.Certificate, pubs []*sm2.PublicKey, key []byte, eci encryptedContentInfo) ([]byte, error) {
+	ris := make([]recipientInfo, len(recipients))
+	for i, cert := range recipients {
+		var err error
+		if ris[i], err = newRecipientInfo(cert, pubs[i], key, nil); err != nil {
+			return nil, err
+		}
+		ris[i].SubjectKeyID = recipientKeyID(pubs[i])
+	}
+	return marshalEnvelope(envelopedDataMulti{
+		Version:              envelopeVersionMulti,
+		RecipientInfos:       ris,
+		EncryptedContentInfo: eci,
+	})
+}
+
+// encryptContent encrypts plaintext with a fresh SM4-CBC key and returns the
+// key along with the encrypted content info.
+func encryptContent(random io.Reader, plaintext []byte) ([]byte, encryptedContentInfo, error) {
+	key, err := sm4.GenerateKey(random)
+	if err != nil {
+		return nil, encryptedContentInfo{}, err
+	}
+	iv, err := sm4.GenerateIV(random)
+	if err != nil {
+		return nil, encryptedContentInfo{}, err
+	}
+
+	c, err := sm4.Init(iv, key)
+	if err != nil {
+		return nil, encryptedContentInfo{}, err
+	}
+	defer c.Zeroize()
+	content, err := c.Sm4Cbc(plaintext, true)
+	if err != nil {
+		return nil, encryptedContentInfo{}, err
+	}
+	ivParam, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, encryptedContentInfo{}, err
+	}
+	return key, encryptedContentInfo{
+		ContentType: oidSM2Data,
+		ContentEncryptionAlgorithm: pkix.AlgorithmIdentifier{
+			Algorithm:  oidSM4CBC,
+			Parameters: asn1.RawValue{FullBytes: ivParam},
+		},
+		EncryptedContent: content,
+	}, nil
+}
+
+// marshalEnvelope wraps the enveloped data ed in a ContentInfo.
+func marshalEnvelope(ed interface{}) ([]byte, error) {
+	inner, err := asn1.Marshal(ed)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(contentInfo{
+		ContentType: oidSM2EnvelopedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: inner},
+	})
+}
+
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// Open decrypts an envelope produced by Seal or SealMulti using the
+// recipient's private key. For multi-recipient envelopes the recipient info
+// that priv can decrypt is used.
+func Open(priv *sm2.PrivateKey, sealed []byte) ([]byte, error) {
+	key, eci, err := openKey(priv, sealed)
+	if err != nil {
+		return nil, err
+	}
+	defer zero(key)
+	return decryptContent(eci, key)
+}
+
+// Rekey decrypts the SM4 content key of sealed with oldKey and wraps it for
+// newRecipients, copying the encrypted payload as is, so large envelopes can
+// be handed to new recipients without re-encrypting the content. The result
+// is a multi-recipient envelope that only newRecipients can Open. Because the
+// content key is unchanged, anyone who already recovered it can still
+// decrypt the payload; seal afresh if that matters.
+func Rekey(sealed []byte, oldKey *sm2.PrivateKey, newRecipients []*x509.Certificate) ([]byte, error) {
+	pubs, err := recipientPublicKeys(newRecipients)
+	if err != nil {
+		return nil, err
+	}
+	key, eci, err := openKey(oldKey, sealed)
+	if err != nil {
+		return nil, err
+	}
+	defer zero(key)
+	return sealMultiKey(newRecipients, pubs, key, eci)
+}
+
+// openKey parses sealed and recovers the content key with priv, returning it
+// together with the still encrypted content.
+func openKey(priv *sm2.PrivateKey, sealed []byte) ([]byte, encryptedContentInfo, error) {
+	if priv == nil {
+		return nil, encryptedContentInfo{}, errors.New("envelope: missing private key")
+	}
+	var info contentInfo
+	rest, err := asn1.Unmarshal(sealed, &info)
+	if err != nil {
+		return nil, encryptedContentInfo{}, err
+	}
+	if len(rest) != 0 {
+		return nil, encryptedContentInfo{}, errors.New("envelope: trailing data after envelope")
+	}
+	if !info.ContentType.Equal(oidSM2EnvelopedData) {
+		return nil, encryptedContentInfo{}, errors.New("envelope: not an SM2 enveloped data structure")
+	}
+	version, err := contentVersion(info.Content.Bytes)
+	if err != nil {
+		return nil, encryptedContentInfo{}, err
+	}
+	switch version {
+	case envelopeVersion:
+		return openV1(priv, info.Content.Bytes)
+	case envelopeVersionMulti:
+		return openMulti(priv, info.Content.Bytes)
+	default:
+		return nil, encryptedContentInfo{}, ErrUnsupportedEnvelopeVersion
+	}
+}
+
+// contentVersion reads the leading version INTEGER of a DER SEQUENCE without
+// assuming anything about the fields that follow it.
+func contentVersion(der []byte) (int, error) {
+	var seq asn1.RawValue
+	if _, err := asn1.Unmarshal(der, &seq); err != nil {
+		return 0, err
+	}
+	if seq.Class != asn1.ClassUniversal || seq.Tag != asn1.TagSequence || !seq.IsCompound {
+		return 0, errors.New("envelope: enveloped data is not a SEQUENCE")
+	}
+	var version int
+	if _, err := asn1.Unmarshal(seq.Bytes, &version); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+func openV1(priv *sm2.PrivateKey, der []byte) ([]byte, encryptedContentInfo, error) {
+	var ed envelopedData
+	rest, err := asn1.Unmarshal(der, &ed)
+	if err != nil {
+		return nil, encryptedContentInfo{}, err
+	}
+	if len(rest) != 0 {
+		return nil, encryptedContentInfo{}, errors.New("envelope: trailing data after enveloped data")
+	}
+	key, err := ed.RecipientInfo.decryptKey(priv)
+	if err != nil {
+		return nil, encryptedContentInfo{}, err
+	}
+	return key, ed.EncryptedContentInfo, nil
+}
+
+func openMulti(priv *sm2.PrivateKey, der []byte) ([]byte, encryptedContentInfo, error) {
+	var ed envelopedDataMulti
+	rest, err := asn1.Unmarshal(der, &ed)
+	if err != nil {
+		return nil, encryptedContentInfo{}, err
+	}
+	if len(rest) != 0 {
+		return nil, encryptedContentInfo{}, errors.New("envelope: trailing data after enveloped data")
+	}
+	for _, ri := range candidateRecipients(ed.RecipientInfos, recipientKeyID(&priv.PublicKey)) {
+		if key, err := ri.decryptKey(priv); err == nil {
+			return key, ed.EncryptedContentInfo, nil
+		}
+	}
+	return nil, encryptedContentInfo{}, errors.New("envelope: no recipient info for this private key")
+}
+
+// candidateRecipients returns the recipient infos worth trying for the key
+// with the given key ID: those tagged with that ID, followed by untagged ones.
+// Untagged infos (envelopes from before key IDs were written) have to be
+// tried one by one, since issuer and serial cannot be matched from a private
+// key; the C3 check of the SM2 ciphertext reliably rejects the wrong key.
+func candidateRecipients(ris []recipientInfo, keyID []byte) []*recipientInfo {
+	var tagged, untagged []*recipientInfo
+	for i := range ris {
+		switch {
+		case len(ris[i].SubjectKeyID) == 0:
+			untagged = append(untagged, &ris[i])
+		case bytes.Equal(ris[i].SubjectKeyID, keyID):
+			tagged = append(tagged, &ris[i])
+		}
+	}
+	return append(tagged, untagged...)
+}
+
+// decryptContent decrypts eci with the SM4 key and clears the key.
+func decryptContent(eci encryptedContentInfo, key []byte) ([]byte, error) {
+	switch alg := eci.ContentEncryptionAlgorithm.Algorithm; {
+	case alg.Equal(oidSM4GCM):
+		return decryptContentGCM(eci, key)
+	case !alg.Equal(oidSM4CBC):
+		return nil, errors.New("envelope: unsupported content encryption algorithm")
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(eci.ContentEncryptionAlgorithm.Parameters.FullBytes, &iv); err != nil {
+		return nil, err
+	}
+	c, err := sm4.Init(iv, key)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Zeroize()
+	if len(eci.EncryptedContent) == 0 || len(eci.EncryptedContent)%16 != 0 {
+		return nil, errors.New("envelope: malformed encrypted content")
+	}
+	out, err := c.Sm4Cbc(eci.EncryptedContent, false)
+	if err != nil {
+		return nil, ErrDecryptionFailed
+	}
+	return out, nil
+}
@@ -0,0 +1,320 @@
+package envelope
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/asn1"
+	"envelope/sm2"
+	"envelope/x509"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestSealOpen(t *testing.T) {
+	priv, cert := newTestRecipient(t)
+	for _, msg := range [][]byte{nil, []byte("hello"), bytes.Repeat([]byte{7}, 100)} {
+		sealed, err := Seal(cert, msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := Open(priv, sealed)
+		if err != nil {
+			t.Fatalf("%d bytes: %v", len(msg), err)
+		}
+		if !bytes.Equal(got, msg) {
+			t.Errorf("Open = %x, want %x", got, msg)
+		}
+	}
+
+	sealed, err := Seal(cert, []byte("for the recipient only"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _ := newTestRecipient(t)
+	if _, err := Open(other, sealed); err == nil {
+		t.Error("opened with the wrong private key")
+	}
+}
+
+func TestOpenTamperedCBC(t *testing.T) {
+	priv, cert := newTestRecipient(t)
+	// 20字节明文的填充为12个0x0c，翻转倒数第二个密文分组的末字节使填充无效
+	sealed, err := Seal(cert, bytes.Repeat([]byte("a"), 20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ed := parseTestEnvelope(t, sealed)
+	ed.EncryptedContentInfo.EncryptedContent[15] ^= 0x80
+	tampered, err := marshalEnvelope(ed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(priv, tampered); !errors.Is(err, ErrDecryptionFailed) {
+		t.Errorf("tampered ciphertext: %v, want ErrDecryptionFailed", err)
+	}
+}
+
+func TestSealMulti(t *testing.T) {
+	var keys []*sm2.PrivateKey
+	var certs []*x509.Certificate
+	for i := 0; i < 4; i++ {
+		priv, cert := newTestRecipient(t)
+		keys = append(keys, priv)
+		certs = append(certs, cert)
+	}
+	msg := []byte("multi recipient payload")
+	sealed, err := SealMulti(certs[:3], msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, priv := range keys[:3] {
+		got, err := Open(priv, sealed)
+		if err != nil {
+			t.Fatalf("recipient %d: %v", i, err)
+		}
+		if !bytes.Equal(got, msg) {
+			t.Errorf("recipient %d: Open = %q", i, got)
+		}
+	}
+	if _, err := Open(keys[3], sealed); err == nil {
+		t.Error("opened by a key that is not a recipient")
+	}
+	if _, err := SealMulti(nil, msg); err == nil {
+		t.Error("SealMulti accepted an empty recipient list")
+	}
+}
+
+// 各接收者证书的签发者与序列号相同，只能靠密钥标识区分
+func TestOpenMultiByKeyID(t *testing.T) {
+	var keys []*sm2.PrivateKey
+	var certs []*x509.Certificate
+	for i := 0; i < 3; i++ {
+		priv, cert := newTestRecipient(t)
+		keys = append(keys, priv)
+		certs = append(certs, cert)
+	}
+	sealed, err := SealMulti(certs, []byte("tagged"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var info contentInfo
+	if _, err := asn1.Unmarshal(sealed, &info); err != nil {
+		t.Fatal(err)
+	}
+	var ed envelopedDataMulti
+	if _, err := asn1.Unmarshal(info.Content.Bytes, &ed); err != nil {
+		t.Fatal(err)
+	}
+	for i, priv := range keys {
+		id := recipientKeyID(&priv.PublicKey)
+		candidates := candidateRecipients(ed.RecipientInfos, id)
+		if len(candidates) != 1 || !bytes.Equal(candidates[0].SubjectKeyID, id) {
+			t.Fatalf("recipient %d: %d candidates, want only its own block", i, len(candidates))
+		}
+		if got, err := Open(priv, sealed); err != nil || string(got) != "tagged" {
+			t.Errorf("recipient %d: Open = %q, %v", i, got, err)
+		}
+	}
+	outsider, _ := newTestRecipient(t)
+	if n := len(candidateRecipients(ed.RecipientInfos, recipientKeyID(&outsider.PublicKey))); n != 0 {
+		t.Errorf("outsider has %d candidate blocks, want 0", n)
+	}
+
+	// 没有密钥标识的旧信封只能逐个尝试
+	for i := range ed.RecipientInfos {
+		ed.RecipientInfos[i].SubjectKeyID = nil
+	}
+	if n := len(candidateRecipients(ed.RecipientInfos, recipientKeyID(&keys[1].PublicKey))); n != 3 {
+		t.Errorf("untagged envelope: %d candidates, want 3", n)
+	}
+	untagged, err := marshalEnvelope(ed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := Open(keys[2], untagged); err != nil || string(got) != "tagged" {
+		t.Errorf("untagged envelope: Open = %q, %v", got, err)
+	}
+}
+
+func TestOpenFutureVersion(t *testing.T) {
+	priv, _ := newTestRecipient(t)
+	future, err := marshalEnvelope(struct {
+		Version int
+		Payload []byte
+	}{Version: envelopeVersionMulti + 1, Payload: []byte("future")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(priv, future); err != ErrUnsupportedEnvelopeVersion {
+		t.Errorf("Open = %v, want ErrUnsupportedEnvelopeVersion", err)
+	}
+}
+
+func TestRekey(t *testing.T) {
+	oldKey, oldCert := newTestRecipient(t)
+	k1, c1 := newTestRecipient(t)
+	k2, c2 := newTestRecipient(t)
+	msg := bytes.Repeat([]byte("payload"), 5000)
+	for _, opts := range []*SealOptions{nil, {SM4Mode: SM4ModeCBC}} {
+		sealed, err := SealWithOptions(oldCert, msg, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rekeyed, err := Rekey(sealed, oldKey, []*x509.Certificate{c1, c2})
+		if err != nil {
+			t.Fatalf("%+v: %v", opts, err)
+		}
+		if !bytes.Contains(rekeyed, parseTestEnvelope(t, sealed).EncryptedContentInfo.EncryptedContent) {
+			t.Errorf("%+v: Rekey re-encrypted the content", opts)
+		}
+		if _, err := Open(oldKey, rekeyed); err == nil {
+			t.Errorf("%+v: the old key still opens the rekeyed envelope", opts)
+		}
+		for i, priv := range []*sm2.PrivateKey{k1, k2} {
+			if got, err := Open(priv, rekeyed); err != nil || !bytes.Equal(got, msg) {
+				t.Errorf("%+v: new recipient %d: %v", opts, i, err)
+			}
+		}
+		if _, err := Rekey(rekeyed, oldKey, []*x509.Certificate{oldCert}); err == nil {
+			t.Errorf("%+v: Rekey accepted a key that is not a recipient", opts)
+		}
+	}
+}
+
+func TestSignAndSeal(t *testing.T) {
+	signerKey, signerCert := newTestRecipient(t)
+	recipientKey, recipientCert := newTestRecipient(t)
+	msg := []byte("signed payload")
+	sealed, err := SignAndSeal(signerKey, signerCert, recipientCert, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, cert, err := OpenAndVerify(recipientKey, sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, msg) || !cert.Equal(signerCert) {
+		t.Errorf("OpenAndVerify = %q, signer %q", got, cert.Subject.CommonName)
+	}
+	if _, err := SignAndSeal(signerKey, recipientCert, recipientCert, msg); err == nil {
+		t.Error("SignAndSeal accepted a certificate that does not match the signer key")
+	}
+
+	// 分别篡改签名与内容后重新封装，验签都须失败
+	inner, err := Open(recipientKey, sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tamper := range []func(*signedContent){
+		func(sc *signedContent) { sc.Signature[len(sc.Signature)-1] ^= 1 },
+		func(sc *signedContent) { sc.Content[0] ^= 1 },
+	} {
+		var sc signedContent
+		if _, err := asn1.Unmarshal(inner, &sc); err != nil {
+			t.Fatal(err)
+		}
+		tamper(&sc)
+		modified, err := asn1.Marshal(sc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resealed, err := Seal(recipientCert, modified)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := OpenAndVerify(recipientKey, resealed); err != ErrInvalidSignature {
+			t.Errorf("tampered signed content: %v, want ErrInvalidSignature", err)
+		}
+	}
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	priv, cert := newTestRecipient(t)
+	data := make([]byte, 3<<20+17)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	var sealed bytes.Buffer
+	w, err := NewSealWriter(&sealed, cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for off := 0; off < len(data); off += 7777 {
+		end := off + 7777
+		if end > len(data) {
+			end = len(data)
+		}
+		if _, err := w.Write(data[off:end]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	raw := sealed.Bytes()
+
+	r, err := NewOpenReader(bytes.NewReader(raw), priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("read %d bytes that differ from the %d written", len(got), len(data))
+	}
+
+	// 截断到标签内部或密文中部都须由HMAC-SM3标签发现
+	for _, cut := range []int{1, streamTagSize, 1 << 20} {
+		r, err := NewOpenReader(bytes.NewReader(raw[:len(raw)-cut]), priv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ioutil.ReadAll(r); err != ErrStreamAuthentication {
+			t.Errorf("stream truncated by %d bytes: %v, want ErrStreamAuthentication", cut, err)
+		}
+	}
+}
+
+func TestEnvelopeWriteToReadFrom(t *testing.T) {
+	priv, cert := newTestRecipient(t)
+	msg := bytes.Repeat([]byte("x"), 100000)
+	sealed, err := Seal(cert, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		bw := bufio.NewWriter(pw)
+		e := &Envelope{Raw: sealed}
+		for i := 0; i < 2; i++ {
+			if _, err := e.WriteTo(bw); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		pw.CloseWithError(bw.Flush())
+	}()
+	// 连续两个信封应各自完整读出，互不越界
+	for i := 0; i < 2; i++ {
+		var e Envelope
+		n, err := e.ReadFrom(pr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != int64(len(sealed)) {
+			t.Errorf("envelope %d: ReadFrom read %d bytes, want %d", i, n, len(sealed))
+		}
+		if got, err := Open(priv, e.Raw); err != nil || !bytes.Equal(got, msg) {
+			t.Errorf("envelope %d: Open: %v", i, err)
+		}
+	}
+	var e Envelope
+	if _, err := e.ReadFrom(pr); err != io.EOF {
+		t.Errorf("ReadFrom at end of pipe: %v, want io.EOF", err)
+	}
+}
@@ -0,0 +1,145 @@
+package envelope
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"envelope/sm2"
+	"envelope/sm4"
+	"envelope/x509"
+	"errors"
+	"io"
+)
+
+var oidSM4GCM = asn1.ObjectIdentifier{1, 2, 156, 10197, 1, 104, 8}
+
+// SM4 content encryption modes accepted in SealOptions.SM4Mode.
+const (
+	SM4ModeGCM = "GCM"
+	SM4ModeCBC = "CBC"
+)
+
+const gcmTagSize = 16
+
+// SealOptions controls SealWithOptions. The zero value selects the defaults.
+type SealOptions struct {
+	// CipherMode is the layout of the SM2 ciphertext carrying the content
+	// key. The default is sm2.C1C3C2.
+	CipherMode sm2.CiphertextMode
+	// SM4Mode is SM4ModeGCM (the default) or SM4ModeCBC.
+	SM4Mode string
+	// Rand is the source of keys, IVs and SM2 nonces; nil means crypto/rand.
+	Rand io.Reader
+}
+
+// gcmParameters follows the GCMParameters structure of RFC 5084.
+type gcmParameters struct {
+	Nonce  []byte
+	ICVLen int `asn1:"default:12"`
+}
+
+// SealWithOptions is like Seal but lets the caller choose the key ciphertext
+// layout, the SM4 mode and the random source. A nil opts seals with SM4-GCM,
+// C1C3C2 and crypto/rand. The result is opened with Open.
+func SealWithOptions(recipient *x509.Certificate, plaintext []byte, opts *SealOptions) ([]byte, error) {
+	if opts == nil {
+		opts = &SealOptions{}
+	}
+	random := opts.Rand
+	if random == nil {
+		random = rand.Reader
+	}
+	pub, err := recipientPublicKey(recipient)
+	if err != nil {
+		return nil, err
+	}
+
+	var key []byte
+	var eci encryptedContentInfo
+	switch opts.SM4Mode {
+	case "", SM4ModeGCM:
+		key, eci, err = encryptContentGCM(random, plaintext)
+	case SM4ModeCBC:
+		key, eci, err = encryptContent(random, plaintext)
+	default:
+		return nil, errors.New("envelope: unsupported SM4 mode " + opts.SM4Mode)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer zero(key)
+
+	ri, err := newRecipientInfo(recipient, pub, key, opts)
+	if err != nil {
+		return nil, err
+	}
+	return marshalEnvelope(envelopedData{
+		Version:              envelopeVersion,
+		RecipientInfo:        ri,
+		EncryptedContentInfo: eci,
+	})
+}
+
+func encryptContentGCM(random io.Reader, plaintext []byte) ([]byte, encryptedContentInfo, error) {
+	key, err := sm4.GenerateKey(random)
+	if err != nil {
+		return nil, encryptedContentInfo{}, err
+	}
+	aead, c, err := newGCM(key)
+	if err != nil {
+		return nil, encryptedContentInfo{}, err
+	}
+	defer c.Zeroize()
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(random, nonce); err != nil {
+		return nil, encryptedContentInfo{}, err
+	}
+	params, err := asn1.Marshal(gcmParameters{Nonce: nonce, ICVLen: gcmTagSize})
+	if err != nil {
+		return nil, encryptedContentInfo{}, err
+	}
+	return key, encryptedContentInfo{
+		ContentType: oidSM2Data,
+		ContentEncryptionAlgorithm: pkix.AlgorithmIdentifier{
+			Algorithm:  oidSM4GCM,
+			Parameters: asn1.RawValue{FullBytes: params},
+		},
+		EncryptedContent: aead.Seal(nil, nonce, plaintext, nil),
+	}, nil
+}
+
+func decryptContentGCM(eci encryptedContentInfo, key []byte) ([]byte, error) {
+	var params gcmParameters
+	if _, err := asn1.Unmarshal(eci.ContentEncryptionAlgorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, err
+	}
+	aead, c, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Zeroize()
+	if params.ICVLen != gcmTagSize || len(params.Nonce) != aead.NonceSize() {
+		return nil, errors.New("envelope: unsupported GCM parameters")
+	}
+	out, err := aead.Open(nil, params.Nonce, eci.EncryptedContent, nil)
+	if err != nil {
+		return nil, ErrDecryptionFailed
+	}
+	return out, nil
+}
+
+// newGCM returns SM4-GCM for key together with the block so the caller can
+// zeroize it.
+func newGCM(key []byte) (cipher.AEAD, *sm4.SM4, error) {
+	block, err := sm4.NewCipher(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	c := block.(*sm4.SM4)
+	aead, err := c.NewGCM()
+	if err != nil {
+		return nil, nil, err
+	}
+	return aead, c, nil
+}
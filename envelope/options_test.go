@@ -0,0 +1,115 @@
+package envelope
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"envelope/sm2"
+	"envelope/x509"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func newTestRecipient(t *testing.T) (*sm2.PrivateKey, *x509.Certificate) {
+	priv, err := sm2.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(78),
+		Subject:      pkix.Name{CommonName: "envelope recipient"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(tmpl, tmpl, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return priv, cert
+}
+
+func TestSealWithOptionsRoundTrip(t *testing.T) {
+	priv, cert := newTestRecipient(t)
+	msg := []byte("sealed with options")
+	for _, opts := range []*SealOptions{
+		nil,
+		{CipherMode: sm2.C1C2C3},
+		{SM4Mode: SM4ModeCBC, Rand: rand.Reader},
+		{SM4Mode: SM4ModeGCM, CipherMode: sm2.C1C2C3},
+	} {
+		sealed, err := SealWithOptions(cert, msg, opts)
+		if err != nil {
+			t.Fatalf("%+v: %v", opts, err)
+		}
+		got, err := Open(priv, sealed)
+		if err != nil {
+			t.Fatalf("%+v: %v", opts, err)
+		}
+		if !bytes.Equal(got, msg) {
+			t.Errorf("%+v: Open = %q", opts, got)
+		}
+
+		// 与Seal相同地按签发者和序列号标识接收者
+		ed := parseTestEnvelope(t, sealed)
+		ri := ed.RecipientInfo
+		if !bytes.Equal(ri.IssuerAndSerialNumber.IssuerName.FullBytes, cert.RawIssuer) || ri.IssuerAndSerialNumber.SerialNumber.Cmp(cert.SerialNumber) != 0 {
+			t.Errorf("%+v: recipient is not identified by issuer and serial", opts)
+		}
+		wantAlg := oidSM4GCM
+		if opts != nil && opts.SM4Mode == SM4ModeCBC {
+			wantAlg = oidSM4CBC
+		}
+		if alg := ed.EncryptedContentInfo.ContentEncryptionAlgorithm.Algorithm; !alg.Equal(wantAlg) {
+			t.Errorf("%+v: content algorithm %v, want %v", opts, alg, wantAlg)
+		}
+	}
+	if _, err := SealWithOptions(cert, msg, &SealOptions{SM4Mode: "ECB"}); err == nil {
+		t.Error("unsupported SM4 mode accepted")
+	}
+}
+
+func TestSealWithOptionsGCMTamper(t *testing.T) {
+	priv, cert := newTestRecipient(t)
+	sealed, err := SealWithOptions(cert, bytes.Repeat([]byte("gcm"), 20), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ed := parseTestEnvelope(t, sealed)
+	ct := ed.EncryptedContentInfo.EncryptedContent
+	start := bytes.Index(sealed, ct)
+	if start < 0 {
+		t.Fatal("encrypted content not found")
+	}
+	// 篡改密文首字节与标签末字节都须导致认证失败
+	for _, i := range []int{start, start + len(ct) - 1} {
+		tampered := append([]byte(nil), sealed...)
+		tampered[i] ^= 1
+		if _, err := Open(priv, tampered); !errors.Is(err, ErrDecryptionFailed) {
+			t.Errorf("tampered byte %d: %v, want ErrDecryptionFailed", i-start, err)
+		}
+	}
+
+	other, _ := newTestRecipient(t)
+	if _, err := Open(other, sealed); err == nil {
+		t.Error("opened with the wrong private key")
+	}
+}
+
+func parseTestEnvelope(t *testing.T, sealed []byte) envelopedData {
+	var info contentInfo
+	if _, err := asn1.Unmarshal(sealed, &info); err != nil {
+		t.Fatal(err)
+	}
+	var ed envelopedData
+	if _, err := asn1.Unmarshal(info.Content.Bytes, &ed); err != nil {
+		t.Fatal(err)
+	}
+	return ed
+}
@@ -0,0 +1,76 @@
+package envelope
+
+import (
+	"crypto/rand"
+	"encoding/asn1"
+	"envelope/sm2"
+	"envelope/x509"
+	"errors"
+)
+
+// ErrInvalidSignature is returned by OpenAndVerify when the embedded signature
+// does not verify against the embedded signer certificate.
+var ErrInvalidSignature = errors.New("envelope: invalid signature")
+
+// signedContent is the plaintext of a signed envelope: the payload, the
+// signer's certificate and the DER SM2 signature over the payload.
+type signedContent struct {
+	Content    []byte
+	SignerCert asn1.RawValue
+	Signature  []byte
+}
+
+// SignAndSeal signs plaintext with signerKey (default user ID), embeds the
+// signature and signerCert next to it, and seals the result for recipientCert.
+func SignAndSeal(signerKey *sm2.PrivateKey, signerCert, recipientCert *x509.Certificate, plaintext []byte) ([]byte, error) {
+	if signerKey == nil || signerCert == nil {
+		return nil, errors.New("envelope: missing signer key or certificate")
+	}
+	if !signerKey.PublicKey.Equal(signerCert.PublicKey) {
+		return nil, errors.New("envelope: signer certificate does not match signer key")
+	}
+	sig, err := signerKey.Sign(rand.Reader, plaintext, nil)
+	if err != nil {
+		return nil, err
+	}
+	inner, err := asn1.Marshal(signedContent{
+		Content:    plaintext,
+		SignerCert: asn1.RawValue{FullBytes: signerCert.Raw},
+		Signature:  sig,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return Seal(recipientCert, inner)
+}
+
+// OpenAndVerify opens an envelope produced by SignAndSeal, verifies the
+// signature and returns the plaintext together with the signer certificate.
+// The certificate is only what the sender embedded; callers must still check
+// that it chains to a trusted root before relying on the signer's identity.
+func OpenAndVerify(priv *sm2.PrivateKey, sealed []byte) ([]byte, *x509.Certificate, error) {
+	inner, err := Open(priv, sealed)
+	if err != nil {
+		return nil, nil, err
+	}
+	var sc signedContent
+	rest, err := asn1.Unmarshal(inner, &sc)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(rest) != 0 {
+		return nil, nil, errors.New("envelope: trailing data after signed content")
+	}
+	cert, err := x509.ParseCertificate(sc.SignerCert.FullBytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	pub, ok := cert.PublicKey.(*sm2.PublicKey)
+	if !ok {
+		return nil, nil, errors.New("envelope: signer certificate does not hold an SM2 public key")
+	}
+	if !pub.Verify(sc.Content, sc.Signature) {
+		return nil, nil, ErrInvalidSignature
+	}
+	return sc.Content, cert, nil
+}
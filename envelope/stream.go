@@ -0,0 +1,268 @@
+package envelope
+
+import (
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"envelope/sm2"
+	"envelope/sm3"
+	"envelope/sm4"
+	"envelope/x509"
+	"errors"
+	"hash"
+	"io"
+)
+
+// A stream envelope is a DER streamHeader followed by the SM4-CTR encrypted
+// payload and a 32-byte HMAC-SM3 tag over the header and the ciphertext.
+// The recipient's SM2 key encrypts the 16-byte SM4 key followed by the
+// 32-byte HMAC key.
+
+var oidSM4CTR = asn1.ObjectIdentifier{1, 2, 156, 10197, 1, 104, 7}
+
+const (
+	streamVersion   = 1
+	streamKeySize   = 16
+	streamMACKeyLen = 32
+	streamTagSize   = sm3.Size
+	maxHeaderSize   = 64 << 10
+)
+
+// ErrStreamAuthentication is returned by the reader from NewOpenReader when
+// the stream tag does not match, i.e. the data was truncated or modified.
+var ErrStreamAuthentication = errors.New("envelope: stream authentication failed")
+
+type streamHeader struct {
+	Version                    int
+	RecipientInfo              recipientInfo
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+}
+
+type sealWriter struct {
+	w      io.Writer
+	stream cipher.Stream
+	mac    hash.Hash
+	block  *sm4.SM4
+	closed bool
+}
+
+// NewSealWriter writes a stream envelope header for recipientCert to w and
+// returns a writer that encrypts everything written to it. Close must be
+// called to write the authentication tag; it does not close w.
+func NewSealWriter(w io.Writer, recipientCert *x509.Certificate) (io.WriteCloser, error) {
+	pub, err := recipientPublicKey(recipientCert)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]byte, streamKeySize+streamMACKeyLen)
+	if _, err := io.ReadFull(rand.Reader, keys); err != nil {
+		return nil, err
+	}
+	iv, err := sm4.GenerateIV(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	ri, err := newRecipientInfo(recipientCert, pub, keys, nil)
+	if err != nil {
+		return nil, err
+	}
+	ivParam, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, err
+	}
+	header, err := asn1.Marshal(streamHeader{
+		Version:       streamVersion,
+		RecipientInfo: ri,
+		ContentEncryptionAlgorithm: pkix.AlgorithmIdentifier{
+			Algorithm:  oidSM4CTR,
+			Parameters: asn1.RawValue{FullBytes: ivParam},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sw, err := newStreamState(keys, iv)
+	if err != nil {
+		return nil, err
+	}
+	sw.w = w
+	sw.mac.Write(header)
+	if _, err := w.Write(header); err != nil {
+		sw.block.Zeroize()
+		return nil, err
+	}
+	return sw, nil
+}
+
+// newStreamState sets up the cipher and MAC for keys and clears keys.
+func newStreamState(keys, iv []byte) (*sealWriter, error) {
+	c, err := sm4.Init(iv, keys[:streamKeySize])
+	mac := sm3.NewHMAC(keys[streamKeySize:])
+	zero(keys)
+	if err != nil {
+		return nil, err
+	}
+	return &sealWriter{stream: cipher.NewCTR(c, iv), mac: mac, block: c}, nil
+}
+
+func (sw *sealWriter) Write(p []byte) (int, error) {
+	if sw.closed {
+		return 0, errors.New("envelope: write to closed seal writer")
+	}
+	ct := make([]byte, len(p))
+	sw.stream.XORKeyStream(ct, p)
+	sw.mac.Write(ct)
+	return sw.w.Write(ct)
+}
+
+// Close writes the authentication tag and clears the session keys.
+func (sw *sealWriter) Close() error {
+	if sw.closed {
+		return nil
+	}
+	sw.closed = true
+	sw.block.Zeroize()
+	_, err := sw.w.Write(sw.mac.Sum(nil))
+	return err
+}
+
+type openReader struct {
+	r      io.Reader
+	stream cipher.Stream
+	mac    hash.Hash
+	block  *sm4.SM4
+	buf    []byte
+	tail   []byte // up to streamTagSize bytes that may be the tag
+	err    error
+}
+
+// NewOpenReader reads a stream envelope header from r, decrypts the session
+// keys with priv and returns a reader for the plaintext.
+//
+// Plaintext is returned as it is decrypted, before the tag at the end of the
+// stream has been checked. Callers must treat the data as unauthenticated
+// until Read returns io.EOF; a mismatched tag is reported as
+// ErrStreamAuthentication instead.
+func NewOpenReader(r io.Reader, priv *sm2.PrivateKey) (io.Reader, error) {
+	if priv == nil {
+		return nil, errors.New("envelope: missing private key")
+	}
+	header, err := readDER(r, maxHeaderSize)
+	if err != nil {
+		return nil, err
+	}
+	var sh streamHeader
+	rest, err := asn1.Unmarshal(header, &sh)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, errors.New("envelope: trailing data after stream header")
+	}
+	if sh.Version != streamVersion {
+		return nil, ErrUnsupportedEnvelopeVersion
+	}
+	if !sh.ContentEncryptionAlgorithm.Algorithm.Equal(oidSM4CTR) {
+		return nil, errors.New("envelope: unsupported content encryption algorithm")
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(sh.ContentEncryptionAlgorithm.Parameters.FullBytes, &iv); err != nil {
+		return nil, err
+	}
+	keys, err := sh.RecipientInfo.decryptKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) != streamKeySize+streamMACKeyLen {
+		return nil, errors.New("envelope: malformed stream session key")
+	}
+	st, err := newStreamState(keys, iv)
+	if err != nil {
+		return nil, err
+	}
+	st.mac.Write(header)
+	return &openReader{r: r, stream: st.stream, mac: st.mac, block: st.block, tail: make([]byte, 0, streamTagSize)}, nil
+}
+
+func (or *openReader) Read(p []byte) (int, error) {
+	if or.err != nil {
+		return 0, or.err
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	need := len(p) + streamTagSize
+	if cap(or.buf) < need {
+		or.buf = make([]byte, need)
+	}
+	buf := or.buf[:need]
+	n := copy(buf, or.tail)
+	m, err := or.r.Read(buf[n:])
+	n += m
+
+	emit := 0
+	if n > streamTagSize {
+		emit = n - streamTagSize
+		or.mac.Write(buf[:emit])
+		or.stream.XORKeyStream(p[:emit], buf[:emit])
+	}
+	or.tail = append(or.tail[:0], buf[emit:n]...)
+
+	switch {
+	case err == io.EOF:
+		if len(or.tail) == streamTagSize && hmac.Equal(or.mac.Sum(nil), or.tail) {
+			or.err = io.EOF
+		} else {
+			or.err = ErrStreamAuthentication
+		}
+	case err != nil:
+		or.err = err
+	}
+	if or.err != nil {
+		or.block.Zeroize()
+	}
+	if emit > 0 {
+		return emit, nil
+	}
+	return 0, or.err
+}
+
+// readDER reads one DER SEQUENCE of at most limit bytes from r without
+// reading past its end.
+func readDER(r io.Reader, limit int) ([]byte, error) {
+	hdr := make([]byte, 2, 6)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, err
+	}
+	if hdr[0] != 0x30 {
+		return nil, errors.New("envelope: input does not start with a DER SEQUENCE")
+	}
+	n := int(hdr[1])
+	if n&0x80 != 0 {
+		k := n & 0x7f
+		if k == 0 || k > 4 {
+			return nil, errors.New("envelope: malformed DER length")
+		}
+		lb := hdr[2 : 2+k]
+		if _, err := io.ReadFull(r, lb); err != nil {
+			return nil, err
+		}
+		hdr = hdr[:2+k]
+		n = 0
+		for _, b := range lb {
+			n = n<<8 | int(b)
+		}
+	}
+	if n < 0 || n > limit-len(hdr) {
+		return nil, errors.New("envelope: DER object too large")
+	}
+	der := make([]byte, len(hdr)+n)
+	copy(der, hdr)
+	if _, err := io.ReadFull(r, der[len(hdr):]); err != nil {
+		return nil, err
+	}
+	return der, nil
+}
@@ -0,0 +1,47 @@
+package envelope
+
+import "io"
+
+// MaxEnvelopeSize bounds how many bytes Envelope.ReadFrom accepts.
+var MaxEnvelopeSize = 64 << 20
+
+// Envelope holds the DER encoding of a sealed envelope as produced by Seal,
+// SealMulti or SealWithOptions, so that it can be written to and read from
+// streams such as pipes and buffered writers. Raw is passed to Open to decrypt it.
+type Envelope struct {
+	Raw []byte
+}
+
+var (
+	_ io.WriterTo   = (*Envelope)(nil)
+	_ io.ReaderFrom = (*Envelope)(nil)
+)
+
+// WriteTo implements io.WriterTo by writing the DER encoding to w.
+func (e *Envelope) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(e.Raw)
+	return int64(n), err
+}
+
+// ReadFrom implements io.ReaderFrom. It reads exactly one DER envelope from
+// r, leaving any following bytes unread, and replaces Raw with it.
+func (e *Envelope) ReadFrom(r io.Reader) (int64, error) {
+	cr := &countingReader{r: r}
+	der, err := readDER(cr, MaxEnvelopeSize)
+	if err != nil {
+		return cr.n, err
+	}
+	e.Raw = der
+	return cr.n, nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
@@ -125,12 +125,15 @@ cipherPlain: 对称密钥
 //export SymEncryptData
 func SymEncryptData(msg,cipherPlain *C.char,output **C.char) int {
 	c := C.GoString(cipherPlain)
-	kdf := pkcs12.KDF([]byte(c))
+	kdf := pkcs12.KDF([]byte(c), 32)
 	iv := make([]byte,16)
 	key := make([]byte,16)
 	copy(iv,kdf[:16])
 	copy(key,kdf[16:])
-	sm4Cipher := sm4.Init(iv, key)
+	sm4Cipher, err := sm4.Init(iv, key)
+	if err != nil {
+		return 0
+	}
 
 	out, err := sm4Cipher.Sm4Cbc([]byte(C.GoString(msg)), true)
 	if err != nil {
@@ -151,12 +154,15 @@ func SymDecryptData(msg,cipherPlain *C.char,output **C.char) int {
 	if cs[0] != "02" {
 		return 0
 	}
-	kdf := pkcs12.KDF([]byte(cs[1]))
+	kdf := pkcs12.KDF([]byte(cs[1]), 32)
 	iv := make([]byte,16)
 	key := make([]byte,16)
 	copy(iv,kdf[:16])
 	copy(key,kdf[16:])
-	sm4Cipher := sm4.Init(iv, key)
+	sm4Cipher, err := sm4.Init(iv, key)
+	if err != nil {
+		return 0
+	}
 
 	m, err := base64.StdEncoding.DecodeString(C.GoString(msg))
 	if err != nil {
@@ -13,6 +13,7 @@ func GetPrivateKeyFromPfxFile(file, password string) (*rsa.PrivateKey, error) {
 	if err != nil {
 		return nil, err
 	}
+	defer open.Close()
 
 	pfxData, err := ioutil.ReadAll(open)
 	if err != nil {
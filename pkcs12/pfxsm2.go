@@ -0,0 +1,441 @@
+package pkcs12
+
+/*
+	SM2私钥与PKCS#12 (.pfx) 互转
+	golang.org/x/crypto/pkcs12 只能解析标准库支持的私钥，无法读取SM2私钥，
+	因此这里实现转换所需的最小PKCS#12编解码 (RFC 7292)：
+	私钥使用pbeWithSHAAnd3-KeyTripleDES-CBC加密，证书不加密，完整性使用HMAC-SHA1
+*/
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"envelope/sm2"
+	"envelope/x509"
+	"fmt"
+	"io"
+	"math/big"
+	"unicode/utf16"
+)
+
+var (
+	oidDataContentType            = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidEncryptedDataContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 6}
+	oidKeyBag                     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 1}
+	oidPKCS8ShroudedKeyBag        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 2}
+	oidCertBag                    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidCertTypeX509Certificate    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+	oidPBEWithSHAAnd3KeyTripleDES = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 3}
+	oidSHA1                       = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+)
+
+const (
+	pfxVersion    = 3
+	pfxIterations = 2048
+	pfxSaltSize   = 8
+)
+
+type pfxPdu struct {
+	Version  int
+	AuthSafe pfxContentInfo
+	MacData  pfxMacData `asn1:"optional"`
+}
+
+type pfxContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type pfxMacData struct {
+	Mac        pfxDigestInfo
+	MacSalt    []byte
+	Iterations int `asn1:"optional,default:1"`
+}
+
+type pfxDigestInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Digest    []byte
+}
+
+type pfxEncryptedData struct {
+	Version              int
+	EncryptedContentInfo pfxEncryptedContentInfo
+}
+
+type pfxEncryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           []byte `asn1:"tag:0,optional"`
+}
+
+type pfxSafeBag struct {
+	Id         asn1.ObjectIdentifier
+	Value      asn1.RawValue  `asn1:"explicit,tag:0"`
+	Attributes []pfxAttribute `asn1:"set,optional"`
+}
+
+type pfxAttribute struct {
+	Id    asn1.ObjectIdentifier
+	Value asn1.RawValue `asn1:"set"`
+}
+
+type pfxCertBag struct {
+	Id   asn1.ObjectIdentifier
+	Data []byte `asn1:"tag:0,explicit"`
+}
+
+type pfxEncryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type pfxPBEParams struct {
+	Salt       []byte
+	Iterations int
+}
+
+/*
+将DecodeSm2可解析的SM2文件 (DER) 转换为PKCS#12，证书一并写入
+*/
+func ConvertSm2ToPfx(sm2Data []byte, srcPass, dstPass string) ([]byte, error) {
+	priv, cert, err := DecodeSm2(sm2Data, srcPass)
+	if err != nil {
+		return nil, err
+	}
+	return encodeSm2Pfx(priv, []*x509.Certificate{cert}, dstPass)
+}
+
+/*
+将含SM2私钥的PKCS#12转换为SM2文件 (EncodeSm2输出的DER)
+pfx中必须有与私钥匹配的证书；仅支持3DES加密的pfx，例如ConvertSm2ToPfx的输出
+*/
+func ConvertPfxToSm2(pfxData []byte, srcPass, dstPass string) ([]byte, error) {
+	priv, certs, err := decodeSm2Pfx(pfxData, srcPass)
+	if err != nil {
+		return nil, err
+	}
+	for _, cert := range certs {
+		if priv.PublicKey.Equal(cert.PublicKey) {
+			return EncodeSm2(priv, cert, dstPass)
+		}
+	}
+	return nil, fmt.Errorf("%w: no certificate matches the SM2 private key", ErrMalformedData)
+}
+
+func encodeSm2Pfx(priv *sm2.PrivateKey, certs []*x509.Certificate, password string) ([]byte, error) {
+	pw := bmpPassword(password)
+
+	var certBags []pfxSafeBag
+	for _, cert := range certs {
+		bag, err := asn1.Marshal(pfxCertBag{Id: oidCertTypeX509Certificate, Data: cert.Raw})
+		if err != nil {
+			return nil, err
+		}
+		certBags = append(certBags, pfxSafeBag{Id: oidCertBag, Value: explicitRaw(bag)})
+	}
+
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	salt, err := randomSalt()
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := pbeCrypt(pkcs8, pw, salt, pfxIterations, true)
+	if err != nil {
+		return nil, err
+	}
+	params, err := asn1.Marshal(pfxPBEParams{Salt: salt, Iterations: pfxIterations})
+	if err != nil {
+		return nil, err
+	}
+	keyBag, err := asn1.Marshal(pfxEncryptedPrivateKeyInfo{
+		Algorithm:     pkix.AlgorithmIdentifier{Algorithm: oidPBEWithSHAAnd3KeyTripleDES, Parameters: asn1.RawValue{FullBytes: params}},
+		EncryptedData: encrypted,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var authSafe []pfxContentInfo
+	for _, bags := range [][]pfxSafeBag{certBags, {{Id: oidPKCS8ShroudedKeyBag, Value: explicitRaw(keyBag)}}} {
+		ci, err := dataContentInfo(bags)
+		if err != nil {
+			return nil, err
+		}
+		authSafe = append(authSafe, ci)
+	}
+	authSafeDER, err := asn1.Marshal(authSafe)
+	if err != nil {
+		return nil, err
+	}
+	content, err := asn1.Marshal(authSafeDER)
+	if err != nil {
+		return nil, err
+	}
+
+	macSalt, err := randomSalt()
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(pfxPdu{
+		Version:  pfxVersion,
+		AuthSafe: pfxContentInfo{ContentType: oidDataContentType, Content: explicitRaw(content)},
+		MacData: pfxMacData{
+			Mac: pfxDigestInfo{
+				Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA1, Parameters: asn1.NullRawValue},
+				Digest:    pfxMAC(authSafeDER, pw, macSalt, pfxIterations),
+			},
+			MacSalt:    macSalt,
+			Iterations: pfxIterations,
+		},
+	})
+}
+
+func decodeSm2Pfx(data []byte, password string) (*sm2.PrivateKey, []*x509.Certificate, error) {
+	pw := bmpPassword(password)
+
+	var pfx pfxPdu
+	rest, err := asn1.Unmarshal(data, &pfx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrMalformedData, err)
+	}
+	if len(rest) != 0 {
+		return nil, nil, fmt.Errorf("%w: %d bytes after PFX", ErrTrailingData, len(rest))
+	}
+	if pfx.Version != pfxVersion || !pfx.AuthSafe.ContentType.Equal(oidDataContentType) {
+		return nil, nil, fmt.Errorf("%w: only password integrity PFX files are supported", ErrUnsupportedFormat)
+	}
+	var authSafeDER []byte
+	if _, err := asn1.Unmarshal(pfx.AuthSafe.Content.Bytes, &authSafeDER); err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrMalformedData, err)
+	}
+	if !pfx.MacData.Mac.Algorithm.Algorithm.Equal(oidSHA1) {
+		return nil, nil, fmt.Errorf("%w: PFX MAC algorithm", ErrUnsupportedFormat)
+	}
+	if pfx.MacData.Iterations <= 0 || pfx.MacData.Iterations > maxKDFIterations {
+		return nil, nil, fmt.Errorf("%w: invalid PFX MAC iteration count", ErrMalformedData)
+	}
+	mac := pfxMAC(authSafeDER, pw, pfx.MacData.MacSalt, pfx.MacData.Iterations)
+	if !hmac.Equal(mac, pfx.MacData.Mac.Digest) {
+		return nil, nil, ErrIncorrectPassword
+	}
+
+	var authSafe []pfxContentInfo
+	if _, err := asn1.Unmarshal(authSafeDER, &authSafe); err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrMalformedData, err)
+	}
+	var priv *sm2.PrivateKey
+	var certs []*x509.Certificate
+	for _, ci := range authSafe {
+		bags, err := safeContents(ci, pw)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, bag := range bags {
+			switch {
+			case bag.Id.Equal(oidCertBag):
+				var cb pfxCertBag
+				if _, err := asn1.Unmarshal(bag.Value.Bytes, &cb); err != nil {
+					return nil, nil, fmt.Errorf("%w: %w", ErrMalformedData, err)
+				}
+				if !cb.Id.Equal(oidCertTypeX509Certificate) {
+					continue
+				}
+				cert, err := x509.ParseCertificate(cb.Data)
+				if err != nil {
+					return nil, nil, fmt.Errorf("%w: %w", ErrMalformedData, err)
+				}
+				certs = append(certs, cert)
+			case bag.Id.Equal(oidKeyBag), bag.Id.Equal(oidPKCS8ShroudedKeyBag):
+				if priv != nil {
+					return nil, nil, fmt.Errorf("%w: multiple private keys found", ErrMalformedData)
+				}
+				pkcs8 := bag.Value.Bytes
+				if bag.Id.Equal(oidPKCS8ShroudedKeyBag) {
+					var info pfxEncryptedPrivateKeyInfo
+					if _, err := asn1.Unmarshal(bag.Value.Bytes, &info); err != nil {
+						return nil, nil, fmt.Errorf("%w: %w", ErrMalformedData, err)
+					}
+					if pkcs8, err = pbeDecrypt(info.Algorithm, info.EncryptedData, pw); err != nil {
+						return nil, nil, err
+					}
+				}
+				if priv, err = x509.ParsePKCS8UnecryptedPrivateKey(pkcs8); err != nil {
+					return nil, nil, fmt.Errorf("%w: PFX private key is not an SM2 key", ErrUnsupportedFormat)
+				}
+			}
+		}
+	}
+	if priv == nil {
+		return nil, nil, fmt.Errorf("%w: no private key found", ErrMalformedData)
+	}
+	return priv, certs, nil
+}
+
+// safeContents 返回一个AuthenticatedSafe成员中的SafeBag，支持data与encryptedData
+func safeContents(ci pfxContentInfo, pw []byte) ([]pfxSafeBag, error) {
+	var data []byte
+	switch {
+	case ci.ContentType.Equal(oidDataContentType):
+		if _, err := asn1.Unmarshal(ci.Content.Bytes, &data); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrMalformedData, err)
+		}
+	case ci.ContentType.Equal(oidEncryptedDataContentType):
+		var ed pfxEncryptedData
+		if _, err := asn1.Unmarshal(ci.Content.Bytes, &ed); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrMalformedData, err)
+		}
+		eci := ed.EncryptedContentInfo
+		var err error
+		if data, err = pbeDecrypt(eci.ContentEncryptionAlgorithm, eci.EncryptedContent, pw); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w: PFX content type %v", ErrUnsupportedFormat, ci.ContentType)
+	}
+	var bags []pfxSafeBag
+	if _, err := asn1.Unmarshal(data, &bags); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformedData, err)
+	}
+	return bags, nil
+}
+
+func dataContentInfo(bags []pfxSafeBag) (pfxContentInfo, error) {
+	der, err := asn1.Marshal(bags)
+	if err != nil {
+		return pfxContentInfo{}, err
+	}
+	content, err := asn1.Marshal(der)
+	if err != nil {
+		return pfxContentInfo{}, err
+	}
+	return pfxContentInfo{ContentType: oidDataContentType, Content: explicitRaw(content)}, nil
+}
+
+// explicitRaw 将der包装为 [0] EXPLICIT
+func explicitRaw(der []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der}
+}
+
+func randomSalt() ([]byte, error) {
+	salt := make([]byte, pfxSaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	return salt, nil
+}
+
+func pbeDecrypt(algo pkix.AlgorithmIdentifier, data, pw []byte) ([]byte, error) {
+	if !algo.Algorithm.Equal(oidPBEWithSHAAnd3KeyTripleDES) {
+		return nil, fmt.Errorf("%w: PFX encryption algorithm %v", ErrUnsupportedFormat, algo.Algorithm)
+	}
+	var params pfxPBEParams
+	if _, err := asn1.Unmarshal(algo.Parameters.FullBytes, &params); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformedData, err)
+	}
+	if params.Iterations <= 0 || params.Iterations > maxKDFIterations {
+		return nil, fmt.Errorf("%w: invalid PBE iteration count", ErrMalformedData)
+	}
+	return pbeCrypt(data, pw, params.Salt, params.Iterations, false)
+}
+
+// pbeCrypt 使用pbeWithSHAAnd3-KeyTripleDES-CBC加解密，填充为PKCS#7
+func pbeCrypt(data, pw, salt []byte, iter int, encrypt bool) ([]byte, error) {
+	key := pkcs12KDF(pw, salt, iter, 1, 24)
+	iv := pkcs12KDF(pw, salt, iter, 2, des.BlockSize)
+	block, err := des.NewTripleDESCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if encrypt {
+		pad := des.BlockSize - len(data)%des.BlockSize
+		out := append(append([]byte(nil), data...), bytes.Repeat([]byte{byte(pad)}, pad)...)
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(out, out)
+		return out, nil
+	}
+	if len(data) == 0 || len(data)%des.BlockSize != 0 {
+		return nil, fmt.Errorf("%w: truncated PFX ciphertext", ErrMalformedData)
+	}
+	out := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(out, data)
+	pad := int(out[len(out)-1])
+	if pad == 0 || pad > des.BlockSize || !bytes.Equal(out[len(out)-pad:], bytes.Repeat([]byte{byte(pad)}, pad)) {
+		return nil, ErrIncorrectPassword
+	}
+	return out[:len(out)-pad], nil
+}
+
+func pfxMAC(data, pw, salt []byte, iter int) []byte {
+	mac := hmac.New(sha1.New, pkcs12KDF(pw, salt, iter, 3, sha1.Size))
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// bmpPassword 按RFC 7292 B.1将密码编码为以0x0000结尾的BMPString
+func bmpPassword(password string) []byte {
+	units := utf16.Encode([]rune(password))
+	out := make([]byte, 0, 2*len(units)+2)
+	for _, u := range units {
+		out = append(out, byte(u>>8), byte(u))
+	}
+	return append(out, 0, 0)
+}
+
+// pkcs12KDF 实现RFC 7292 B.2的密钥导出 (SHA-1, v=64, u=20)
+func pkcs12KDF(pw, salt []byte, iter int, id byte, size int) []byte {
+	const v = 64
+	fill := func(b []byte) []byte {
+		if len(b) == 0 {
+			return nil
+		}
+		n := (len(b) + v - 1) / v * v
+		out := make([]byte, n)
+		for i := range out {
+			out[i] = b[i%len(b)]
+		}
+		return out
+	}
+	D := bytes.Repeat([]byte{id}, v)
+	I := append(fill(salt), fill(pw)...)
+
+	var out []byte
+	one := big.NewInt(1)
+	for len(out) < size {
+		h := sha1.New()
+		h.Write(D)
+		h.Write(I)
+		A := h.Sum(nil)
+		for i := 1; i < iter; i++ {
+			h.Reset()
+			h.Write(A)
+			A = h.Sum(A[:0])
+		}
+		out = append(out, A...)
+
+		// I_j = (I_j + B + 1) mod 2^(8v)
+		B := new(big.Int).SetBytes(fill(A)[:v])
+		B.Add(B, one)
+		for j := 0; j < len(I); j += v {
+			Ij := new(big.Int).SetBytes(I[j : j+v])
+			Ij.Add(Ij, B)
+			b := Ij.Bytes()
+			if len(b) > v {
+				b = b[len(b)-v:]
+			}
+			chunk := I[j : j+v]
+			for k := range chunk {
+				chunk[k] = 0
+			}
+			copy(chunk[v-len(b):], b)
+		}
+	}
+	return out[:size]
+}
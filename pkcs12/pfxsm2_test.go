@@ -0,0 +1,119 @@
+package pkcs12
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"testing"
+)
+
+// 期望值取自golang.org/x/crypto/pkcs12的pbkdf_test.go
+func TestPKCS12KDF(t *testing.T) {
+	for _, v := range []struct {
+		pw, salt []byte
+		want     string
+	}{
+		{bmpPassword("sesame"), []byte("\xff\xff\xff\xff\xff\xff\xff\xff"), "7cd9fd3e2b3be7691a44e3bef0f9ea0fb9b897d4e325d9d1"},
+		// I_j在加法后出现前导0字节
+		{[]byte("\x00\x00"), []byte("\xf3\x7e\x05\xb5\x18\x32\x4b\x4b"), "00f759ff47d14dd03665d5943cb3c4a39a2555c02aed66e1"},
+	} {
+		got := hex.EncodeToString(pkcs12KDF(v.pw, v.salt, 2048, 1, 24))
+		if got != v.want {
+			t.Errorf("pkcs12KDF(%x, %x) = %s, want %s", v.pw, v.salt, got, v.want)
+		}
+	}
+}
+
+// testdata/openssl_sm2.pfx由OpenSSL 3生成，证书包为encryptedData：
+// openssl pkcs12 -export -keypbe PBE-SHA1-3DES -certpbe PBE-SHA1-3DES -macalg sha1
+func TestDecodeOpenSSLPfx(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/openssl_sm2.pfx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	priv, certs, err := decodeSm2Pfx(data, testdataPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if priv.D.Cmp(testdataD) != 0 {
+		t.Errorf("D = %X", priv.D)
+	}
+	if len(certs) != 1 || certs[0].Subject.CommonName != "openssl pfx fixture" {
+		t.Fatalf("certificates = %v", certs)
+	}
+	if !priv.PublicKey.Equal(certs[0].PublicKey) {
+		t.Error("certificate does not match the private key")
+	}
+	if _, _, err := decodeSm2Pfx(data, "654321"); !errors.Is(err, ErrIncorrectPassword) {
+		t.Errorf("wrong password: %v", err)
+	}
+
+	sm2Data, err := ConvertPfxToSm2(data, testdataPassword, "dst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, cert, err := DecodeSm2(sm2Data, "dst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.D.Cmp(testdataD) != 0 || !cert.Equal(certs[0]) {
+		t.Error("ConvertPfxToSm2 did not preserve the key and certificate")
+	}
+}
+
+// MAC的迭代次数来自文件本身，超限时应在计算MAC之前拒绝
+func TestDecodePfxHugeMacIterations(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/openssl_sm2.pfx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pfx pfxPdu
+	if _, err := asn1.Unmarshal(data, &pfx); err != nil {
+		t.Fatal(err)
+	}
+	for _, iter := range []int{-1, maxKDFIterations + 1, 1<<31 - 1} {
+		pfx.MacData.Iterations = iter
+		hostile, err := asn1.Marshal(pfx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := decodeSm2Pfx(hostile, testdataPassword); !errors.Is(err, ErrMalformedData) {
+			t.Errorf("iterations %d: decodeSm2Pfx error = %v, want ErrMalformedData", iter, err)
+		}
+	}
+}
+
+func TestConvertSm2ToPfx(t *testing.T) {
+	sm2Data, err := readSm2File("testdata/cfca_v1.sm2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pfx, err := ConvertSm2ToPfx(sm2Data, testdataPassword, "dst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ConvertSm2ToPfx(sm2Data, "654321", "dst"); !errors.Is(err, ErrIncorrectPassword) {
+		t.Errorf("wrong source password: %v", err)
+	}
+	priv, certs, err := decodeSm2Pfx(pfx, "dst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if priv.D.Cmp(testdataD) != 0 || len(certs) != 1 || certs[0].Subject.CommonName != "sm2 file fixture" {
+		t.Error("ConvertSm2ToPfx did not preserve the key and certificate")
+	}
+
+	back, err := ConvertPfxToSm2(pfx, "dst", "again")
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, _, err := DecodeSm2(back, "again")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(k.D.Bytes(), testdataD.Bytes()) {
+		t.Error("round trip changed the private key")
+	}
+}
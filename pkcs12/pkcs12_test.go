@@ -0,0 +1,869 @@
+package pkcs12
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	stdx509 "crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"envelope/sm2"
+	"envelope/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 测试数据中的SM2文件均使用密码123456，由独立实现(Python SM3 + openssl sm4-cbc)生成
+const testdataPassword = "123456"
+
+var testdataD, _ = new(big.Int).SetString("3945208F7B2144B13F36E38AC6D39F95889393692860B51A42FB81EF4DF7C5B8", 16)
+
+// 期望值由Python hashlib.pbkdf2_hmac("sm3", ...)计算
+func TestDeriveKeyPBKDF2SM3(t *testing.T) {
+	for _, v := range []struct {
+		password, salt string
+		iter, keyLen   int
+		want           string
+	}{
+		{"password", "salt", 1, 32, "4612f922a1fdcefaf4312fc6f8f3322b489cbf24f2ea361b44c2bd8fa2c6dcb0"},
+		{"password", "salt", 2, 32, "fee723a2bc966e11dffb66133f4e8df577383c78ade30e3298edbd3e54ed85b7"},
+		{"password", "saltSALTsaltSALTsaltSALT", 4096, 64, "7325f883285427dca1ce4c63cdc4964d3bfbae172e4b1c8c1494d535d79313281fe6b62ac13d29e46ead46c924d4eb30f2dc07b5307f379c52f6fda3486809db"},
+	} {
+		got := hex.EncodeToString(DeriveKey([]byte(v.password), []byte(v.salt), v.iter, v.keyLen))
+		if got != v.want {
+			t.Errorf("DeriveKey(%q, %q, %d, %d) = %s, want %s", v.password, v.salt, v.iter, v.keyLen, got, v.want)
+		}
+	}
+}
+
+// 期望值为SM3("123456" || 00000001) || SM3(... || 00000002) || SM3(... || 00000003)的前70字节，由Python hashlib计算
+func TestKDF(t *testing.T) {
+	want, _ := hex.DecodeString("ae7d71fade39ff485211a4f6df1146b974119825f27135727cfbede450c0e19b" +
+		"96b41eef1da1ba0291887f51b4796a6ca1f8d52121429d0c768c066b5e04968a" +
+		"9399925dd2d3")
+	for _, n := range []int{1, 16, 31, 32, 33, 63, 64, 70} {
+		if got := KDF([]byte(testdataPassword), n); !bytes.Equal(got, want[:n]) {
+			t.Errorf("KDF(%d) = %x, want %x", n, got, want[:n])
+		}
+	}
+	if got := KDF([]byte(testdataPassword), 0); len(got) != 0 {
+		t.Errorf("KDF(0) = %x", got)
+	}
+}
+
+func TestDeriveSM4KeyIV(t *testing.T) {
+	// KDF("123456", 32) = SM3("123456" || 00000001)
+	want, _ := hex.DecodeString("ae7d71fade39ff485211a4f6df1146b974119825f27135727cfbede450c0e19b")
+	key, iv := DeriveSM4KeyIV([]byte(testdataPassword))
+	if !bytes.Equal(iv, want[:16]) || !bytes.Equal(key, want[16:]) {
+		t.Errorf("DeriveSM4KeyIV = key %x, iv %x", key, iv)
+	}
+}
+
+func TestDecodeLegacyV1File(t *testing.T) {
+	priv, cert, err := GetKeyAndCertFromSm2File("testdata/cfca_v1.sm2", testdataPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if priv.D.Cmp(testdataD) != 0 {
+		t.Errorf("D = %X", priv.D)
+	}
+	if cert.Subject.CommonName != "sm2 file fixture" {
+		t.Errorf("certificate CN = %q", cert.Subject.CommonName)
+	}
+	if _, err := GetPrivateKeyFromSm2File("testdata/cfca_v1.sm2", "654321"); err != ErrIncorrectPassword {
+		t.Errorf("wrong password: %v", err)
+	}
+}
+
+// 短私钥夹具的D为30字节(前两个字节为0且被写入方省略)，SM4填充后密文为32字节
+var testdataShortD, _ = new(big.Int).SetString("C5D6A1E3F4B2C7D8E9FA0B1C2D3E4F5A6B7C8D9EAFB0C1D2E3F405162738", 16)
+
+func TestDecodeSm2Vendors(t *testing.T) {
+	for _, v := range []struct {
+		file   string
+		vendor Sm2Vendor
+		d      *big.Int
+		cn     string
+	}{
+		{"cfca_v1.sm2", Sm2VendorCFCA, testdataD, "sm2 file fixture"},
+		{"base64_v1.sm2", Sm2VendorBase64, testdataD, "sm2 file fixture"},
+		{"cfca_v1_short.sm2", Sm2VendorCFCA, testdataShortD, "sm2 short d fixture"},
+		{"base64_v1_short.sm2", Sm2VendorBase64, testdataShortD, "sm2 short d fixture"},
+	} {
+		data, err := readSm2File("testdata/" + v.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if vendor, err := DetectSm2Vendor(data); err != nil || vendor != v.vendor {
+			t.Errorf("%s: DetectSm2Vendor = %v, %v, want %v", v.file, vendor, err, v.vendor)
+		}
+		for _, vendor := range []Sm2Vendor{Sm2VendorAuto, v.vendor} {
+			priv, cert, err := DecodeSm2WithVendor(data, testdataPassword, vendor)
+			if err != nil {
+				t.Errorf("%s: vendor %v: %v", v.file, vendor, err)
+				continue
+			}
+			if priv.D.Cmp(v.d) != 0 || cert.Subject.CommonName != v.cn {
+				t.Errorf("%s: vendor %v: D = %X, CN = %q", v.file, vendor, priv.D, cert.Subject.CommonName)
+			}
+		}
+		other := Sm2VendorCFCA + Sm2VendorBase64 - v.vendor
+		if _, _, err := DecodeSm2WithVendor(data, testdataPassword, other); err != ErrMalformedKey {
+			t.Errorf("%s: vendor %v: %v, want ErrMalformedKey", v.file, other, err)
+		}
+		if _, _, err := DecodeSm2(data, "654321"); err != ErrIncorrectPassword {
+			t.Errorf("%s: wrong password: %v", v.file, err)
+		}
+	}
+}
+
+// 私钥内容长度不属于32/48(CFCA)或44/64(base64)时无法判断格式
+func TestDecodeSm2AmbiguousLength(t *testing.T) {
+	data, err := readSm2File("testdata/cfca_v1.sm2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm, err := parseSmPdu(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := sm.PrivContent.Content.Bytes
+	b64 := []byte(base64.StdEncoding.EncodeToString(content))
+	for _, c := range [][]byte{
+		content[:16],
+		content[:47],
+		append(content[:48:48], 0),
+		b64[:63],
+		[]byte(base64.StdEncoding.EncodeToString(content[:16])),
+	} {
+		sm.PrivContent.Content = asn1.RawValue{Tag: asn1.TagOctetString, Bytes: c}
+		der, err := asn1.Marshal(*sm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := DetectSm2Vendor(der); err != ErrMalformedKey {
+			t.Errorf("%d bytes: DetectSm2Vendor: %v", len(c), err)
+		}
+		if _, _, err := DecodeSm2(der, testdataPassword); err != ErrMalformedKey {
+			t.Errorf("%d bytes: DecodeSm2: %v", len(c), err)
+		}
+	}
+
+	// 按长度判断为base64，但内容不是base64文本
+	sm.PrivContent.Content = asn1.RawValue{Tag: asn1.TagOctetString, Bytes: append(content[:48:48], content[:16]...)}
+	der, err := asn1.Marshal(*sm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vendor, _ := DetectSm2Vendor(der); vendor != Sm2VendorBase64 {
+		t.Errorf("64 raw bytes: DetectSm2Vendor = %v", vendor)
+	}
+	if _, _, err := DecodeSm2(der, testdataPassword); err != ErrMalformedKey {
+		t.Errorf("64 raw bytes: DecodeSm2: %v", err)
+	}
+	if _, _, err := DecodeSm2WithVendor(data, testdataPassword, Sm2Vendor(9)); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("unknown vendor: %v", err)
+	}
+}
+
+// 解析失败时只返回错误，不向标准日志输出
+func TestGetPrivateKeyFromBytesAutoNoLog(t *testing.T) {
+	var logBuf bytes.Buffer
+	log.SetOutput(&logBuf)
+	defer log.SetOutput(os.Stderr)
+
+	pfx, err := ioutil.ReadFile("testdata/openssl_sm2.pfx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm2File, err := ioutil.ReadFile("testdata/cfca_v1.sm2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range [][]byte{pfx, []byte(base64.StdEncoding.EncodeToString(pfx)), sm2File} {
+		key, err := GetPrivateKeyFromBytesAuto(data, testdataPassword)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if priv, ok := key.(*sm2.PrivateKey); !ok || priv.D.Cmp(testdataD) != 0 {
+			t.Errorf("GetPrivateKeyFromBytesAuto = %T", key)
+		}
+	}
+	if _, err := GetPrivateKeyFromBytesAuto(sm2File, "654321"); err != ErrIncorrectPassword {
+		t.Errorf("wrong password: %v", err)
+	}
+
+	garbage := []byte{0x30, 0x03, 0x02, 0x01, 0x01}
+	if _, _, err := GetPrivateKeyAndChainFromPfx(garbage, testdataPassword); !errors.Is(err, ErrMalformedData) {
+		t.Errorf("GetPrivateKeyAndChainFromPfx: %v", err)
+	}
+	if _, err := GetPrivateKeyFromBytesAuto(garbage, testdataPassword); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("GetPrivateKeyFromBytesAuto: %v", err)
+	}
+	if logBuf.Len() != 0 {
+		t.Errorf("unexpected log output: %q", logBuf.String())
+	}
+}
+
+// testdataKey 返回测试数据中的SM2私钥与证书
+func testdataKey(t *testing.T) (*sm2.PrivateKey, *x509.Certificate) {
+	priv, cert, err := GetKeyAndCertFromSm2File("testdata/cfca_v1.sm2", testdataPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return priv, cert
+}
+
+func TestEncodeSm2RoundTrip(t *testing.T) {
+	priv, cert := testdataKey(t)
+	der, err := EncodeSm2(priv, cert, "new password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, gotCert, err := DecodeSm2(der, "new password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.D.Cmp(priv.D) != 0 || !got.PublicKey.Equal(&priv.PublicKey) || !gotCert.Equal(cert) {
+		t.Error("DecodeSm2 did not recover the encoded key and certificate")
+	}
+	if _, err := EncodeSm2(nil, cert, "pw"); err == nil {
+		t.Error("EncodeSm2 accepted a nil key")
+	}
+	if _, err := EncodeSm2(priv, nil, "pw"); err == nil {
+		t.Error("EncodeSm2 accepted a nil certificate")
+	}
+}
+
+// 截断或损坏的DER须返回ErrMalformedData，不能终止进程
+func TestDecodeSm2CorruptDER(t *testing.T) {
+	der, err := readSm2File("testdata/cfca_v1.sm2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	garbled := append([]byte(nil), der...)
+	garbled[1] ^= 0x7f
+	for name, data := range map[string][]byte{
+		"empty":       nil,
+		"one byte":    der[:1],
+		"header only": der[:4],
+		"half":        der[:len(der)/2],
+		"last byte":   der[:len(der)-1],
+		"bad length":  garbled,
+		"not DER":     []byte("not an SM2 file"),
+	} {
+		if _, _, err := DecodeSm2(data, testdataPassword); !errors.Is(err, ErrMalformedData) {
+			t.Errorf("%s: %v, want ErrMalformedData", name, err)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "corrupt.sm2")
+	if err := ioutil.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(der[:len(der)/2])), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetPrivateKeyFromSm2File(path, testdataPassword); !errors.Is(err, ErrMalformedData) {
+		t.Errorf("GetPrivateKeyFromSm2File: %v, want ErrMalformedData", err)
+	}
+}
+
+// testdata/rsa_chain.pfx由OpenSSL 3生成，含RSA叶子证书的私钥、叶子证书与签发它的CA证书：
+// openssl pkcs12 -export -inkey leaf.key -in leaf.pem -certfile ca.pem -keypbe PBE-SHA1-3DES -certpbe PBE-SHA1-3DES -macalg sha1
+func TestGetPrivateKeyAndChainFromPfx(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/rsa_chain.pfx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, certs, err := GetPrivateKeyAndChainFromPfx(data, testdataPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	priv, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		t.Fatalf("key is %T, want *rsa.PrivateKey", key)
+	}
+	if len(certs) != 2 {
+		t.Fatalf("%d certificates, want 2", len(certs))
+	}
+	byName := make(map[string]*x509.Certificate)
+	for _, c := range certs {
+		byName[c.Subject.CommonName] = c
+	}
+	leaf, ca := byName["pfx chain leaf"], byName["pfx chain CA"]
+	if leaf == nil || ca == nil {
+		t.Fatalf("certificates %q and %q not both present", "pfx chain leaf", "pfx chain CA")
+	}
+	if pub, ok := leaf.PublicKey.(*rsa.PublicKey); !ok || pub.N.Cmp(priv.N) != 0 {
+		t.Error("leaf certificate does not match the private key")
+	}
+	if !bytes.Equal(leaf.RawIssuer, ca.RawSubject) || !ca.IsCA {
+		t.Error("CA certificate is not the leaf's issuer")
+	}
+
+	if k, err := GetPrivateKeyFromBytes(data, ".pfx", testdataPassword); err != nil || k.(*rsa.PrivateKey).N.Cmp(priv.N) != 0 {
+		t.Errorf("GetPrivateKeyFromBytes: %v", err)
+	}
+	if _, _, err := GetPrivateKeyAndChainFromPfx(data, "654321"); err != ErrIncorrectPassword {
+		t.Errorf("wrong password: %v", err)
+	}
+}
+
+// 证书中是RSA公钥时须返回错误而不是panic
+func TestDecodeSm2NonSM2Certificate(t *testing.T) {
+	pfx, err := ioutil.ReadFile("testdata/rsa_chain.pfx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, certs, err := GetPrivateKeyAndChainFromPfx(pfx, testdataPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaCert := certs[0].Raw
+
+	data, err := readSm2File("testdata/cfca_v1.sm2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm, err := parseSmPdu(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm.PubContent.Content = asn1.RawValue{Tag: asn1.TagOctetString, Bytes: rsaCert}
+	der, err := asn1.Marshal(*sm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := DecodeSm2(der, testdataPassword); err == nil || !strings.Contains(err.Error(), "SM2 public key") {
+		t.Errorf("DecodeSm2: %v, want an SM2 public key error", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "rsa.pem")
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: rsaCert}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetPublicKeyFromSM2File(path); err == nil || !strings.Contains(err.Error(), "SM2 public key") {
+		t.Errorf("GetPublicKeyFromSM2File: %v, want an SM2 public key error", err)
+	}
+}
+
+// base64包装与原始密文两种形式须解密出相同的D
+func TestDecryptSm2KeyEncodings(t *testing.T) {
+	raw, err := EncryptSm2Key(testdataPassword, testdataD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(raw) != 48 {
+		t.Fatalf("EncryptSm2Key returned %d bytes, want 48", len(raw))
+	}
+	for name, in := range map[string][]byte{
+		"raw":    raw,
+		"base64": []byte(base64.StdEncoding.EncodeToString(raw)),
+	} {
+		out, err := DecryptSm2Key(testdataPassword, in)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if !bytes.Equal(out, testdataD.Bytes()) {
+			t.Errorf("%s: D = %x, want %x", name, out, testdataD.Bytes())
+		}
+	}
+	// 64个字符但不是合法的base64
+	if _, err := DecryptSm2Key(testdataPassword, bytes.Repeat([]byte("!"), 64)); err != ErrMalformedKey {
+		t.Errorf("invalid base64: %v, want ErrMalformedKey", err)
+	}
+	if _, err := DecryptSm2Key("654321", raw); err != ErrIncorrectPassword {
+		t.Errorf("wrong password: %v, want ErrIncorrectPassword", err)
+	}
+}
+
+func TestEmptyPasswordSm2File(t *testing.T) {
+	priv, cert := testdataKey(t)
+	data, err := GenerateSm2File(priv, cert, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "nopass.sm2")
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	got, err := GetPrivateKeyFromSm2File(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.D.Cmp(priv.D) != 0 {
+		t.Errorf("D = %X, want %X", got.D, priv.D)
+	}
+	if k, err := GetPrivateKeyFromBytes(data, ".sm2", ""); err != nil || k.(*sm2.PrivateKey).D.Cmp(priv.D) != 0 {
+		t.Errorf("GetPrivateKeyFromBytes: %v", err)
+	}
+	if _, err := GetPrivateKeyFromSm2File(path, testdataPassword); err != ErrIncorrectPassword {
+		t.Errorf("non-empty password: %v, want ErrIncorrectPassword", err)
+	}
+}
+
+func TestSm2FileTooLarge(t *testing.T) {
+	dir := t.TempDir()
+	big := filepath.Join(dir, "big.sm2")
+	if err := ioutil.WriteFile(big, bytes.Repeat([]byte("A"), int(MaxFileSize)+4), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetPrivateKeyFromSm2File(big, testdataPassword); err != ErrFileTooLarge {
+		t.Errorf("GetPrivateKeyFromSm2File: %v, want ErrFileTooLarge", err)
+	}
+	if _, err := GetPublicKeyFromSM2File(big); err != ErrFileTooLarge {
+		t.Errorf("GetPublicKeyFromSM2File: %v, want ErrFileTooLarge", err)
+	}
+
+	// 上限可调：调低后正常大小的文件同样被拒绝
+	defer func(old int64) { MaxFileSize = old }(MaxFileSize)
+	MaxFileSize = 64
+	if _, err := GetPrivateKeyFromSm2File("testdata/cfca_v1.sm2", testdataPassword); err != ErrFileTooLarge {
+		t.Errorf("MaxFileSize = 64: %v, want ErrFileTooLarge", err)
+	}
+}
+
+// 反复读取后打开的文件描述符数不应增长，仅在提供/proc/self/fd的系统上检查
+func TestSm2FileReleasesDescriptors(t *testing.T) {
+	openFDs := func() int {
+		entries, err := ioutil.ReadDir("/proc/self/fd")
+		if err != nil {
+			t.Skip("/proc/self/fd not available")
+		}
+		return len(entries)
+	}
+	certPath := filepath.Join(t.TempDir(), "cert.pem")
+	_, cert := testdataKey(t)
+	if err := ioutil.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	before := openFDs()
+	for i := 0; i < 200; i++ {
+		if _, err := GetPrivateKeyFromSm2File("testdata/cfca_v1.sm2", testdataPassword); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := GetPrivateKeyFromSm2File("testdata/cfca_v1.sm2", "654321"); err != ErrIncorrectPassword {
+			t.Fatal(err)
+		}
+		if _, err := GetPublicKeyFromSM2File(certPath); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if after := openFDs(); after > before+2 {
+		t.Errorf("open descriptors grew from %d to %d", before, after)
+	}
+}
+
+func TestSentinelErrors(t *testing.T) {
+	der, err := readSm2File("testdata/cfca_v1.sm2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := DecodeSm2(der[:10], testdataPassword); !errors.Is(err, ErrMalformedData) {
+		t.Errorf("truncated DER: %v, want ErrMalformedData", err)
+	}
+	if _, _, err := DecodeSm2(append(der[:len(der):len(der)], 0), testdataPassword); !errors.Is(err, ErrTrailingData) {
+		t.Errorf("trailing byte: %v, want ErrTrailingData", err)
+	}
+	if _, err := GetPrivateKeyFromBytes(der, ".key", testdataPassword); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("unknown extension: %v, want ErrUnsupportedFormat", err)
+	}
+	if _, _, err := DecodeSm2(der, "654321"); !errors.Is(err, ErrIncorrectPassword) {
+		t.Errorf("wrong password: %v, want ErrIncorrectPassword", err)
+	}
+	// 细分的错误包装对应的通用错误
+	if !errors.Is(ErrMalformedKey, ErrMalformedData) || !errors.Is(ErrUnsupportedAlgorithm, ErrUnsupportedFormat) {
+		t.Error("ErrMalformedKey or ErrUnsupportedAlgorithm does not wrap its sentinel")
+	}
+}
+
+func TestGetPrivateKeyFromBytesExtensions(t *testing.T) {
+	pfx, err := ioutil.ReadFile("testdata/openssl_sm2.pfx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm2File, err := ioutil.ReadFile("testdata/cfca_v1.sm2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		ext  string
+		data []byte
+		ok   bool
+	}{
+		{".sm2", sm2File, true},
+		{".SM2", sm2File, true},
+		{".pfx", pfx, true},
+		{".p12", pfx, true},
+		{".PFX", pfx, true},
+		{".P12", pfx, true},
+		{".key", sm2File, false},
+		{".pem", pfx, false},
+		{"", pfx, false},
+	} {
+		key, err := GetPrivateKeyFromBytes(c.data, c.ext, testdataPassword)
+		if !c.ok {
+			if !errors.Is(err, ErrUnsupportedFormat) {
+				t.Errorf("%q: %v, want ErrUnsupportedFormat", c.ext, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", c.ext, err)
+		} else if priv, ok := key.(*sm2.PrivateKey); !ok || priv.D.Cmp(testdataD) != 0 {
+			t.Errorf("%q: got %T", c.ext, key)
+		}
+	}
+}
+
+func TestGetKeyAndCertFromSm2File(t *testing.T) {
+	for _, file := range []string{"testdata/cfca_v1.sm2", "testdata/cfca_v1_short.sm2"} {
+		priv, cert, err := GetKeyAndCertFromSm2File(file, testdataPassword)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pub, ok := cert.PublicKey.(*sm2.PublicKey)
+		if !ok {
+			t.Fatalf("%s: certificate key is %T", file, cert.PublicKey)
+		}
+		x, y := pub.Curve.ScalarBaseMult(priv.D.Bytes())
+		if x.Cmp(pub.X) != 0 || y.Cmp(pub.Y) != 0 {
+			t.Errorf("%s: certificate public key does not match D", file)
+		}
+		if key, err := GetPrivateKeyFromSm2File(file, testdataPassword); err != nil || key.D.Cmp(priv.D) != 0 {
+			t.Errorf("%s: GetPrivateKeyFromSm2File disagrees: %v", file, err)
+		}
+	}
+}
+
+// 版本2文件在解密前校验HMAC-SM3值：口令正确但校验值被篡改时同样报告密码错误
+func TestSm2FileMACDetectsWrongPassword(t *testing.T) {
+	priv, cert := testdataKey(t)
+	der, err := EncodeSm2(priv, cert, "right")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sm smPdu
+	if _, err := asn1.Unmarshal(der, &sm); err != nil {
+		t.Fatal(err)
+	}
+	if sm.Version != smPduVersionPBKDF || len(sm.MAC) != 32 {
+		t.Fatalf("version %d, %d-byte MAC", sm.Version, len(sm.MAC))
+	}
+	for i := 0; i < 20; i++ {
+		if _, _, err := DecodeSm2(der, fmt.Sprintf("wrong%d", i)); err != ErrIncorrectPassword {
+			t.Fatalf("wrong password %d: %v, want ErrIncorrectPassword", i, err)
+		}
+	}
+
+	sm.MAC[0] ^= 1
+	tampered, err := asn1.Marshal(sm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := DecodeSm2(tampered, "right"); err != ErrIncorrectPassword {
+		t.Errorf("tampered tag: %v, want ErrIncorrectPassword", err)
+	}
+
+	// 没有校验值的版本2文件仍按解密结果判断
+	sm.MAC = nil
+	untagged, err := asn1.Marshal(sm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k, _, err := DecodeSm2(untagged, "right"); err != nil || k.D.Cmp(priv.D) != 0 {
+		t.Errorf("untagged file: %v", err)
+	}
+}
+
+func TestTrailingDataCount(t *testing.T) {
+	der, err := readSm2File("testdata/cfca_v1.sm2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = DecodeSm2(append(der[:len(der):len(der)], 1, 2, 3, 4, 5), testdataPassword)
+	if !errors.Is(err, ErrTrailingData) {
+		t.Fatalf("DecodeSm2: %v, want ErrTrailingData", err)
+	}
+	if !strings.Contains(err.Error(), "5 bytes") {
+		t.Errorf("error %q does not report the 5 trailing bytes", err)
+	}
+}
+
+// testdata/ecdsa_p256.pfx由OpenSSL 3生成，私钥以PKCS#8存放：
+// openssl pkcs12 -export -inkey ec.key -in ec.pem -keypbe PBE-SHA1-3DES -certpbe PBE-SHA1-3DES -macalg sha1
+func TestPfxPKCS8Keys(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/ecdsa_p256.pfx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, certs, err := GetPrivateKeyAndChainFromPfx(data, testdataPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	priv, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		t.Fatalf("key is %T, want *ecdsa.PrivateKey", key)
+	}
+	if len(certs) != 1 || certs[0].Subject.CommonName != "ecdsa pfx fixture" {
+		t.Fatalf("certificates = %v", certs)
+	}
+	if pub, ok := certs[0].PublicKey.(*ecdsa.PublicKey); !ok || !priv.PublicKey.Equal(pub) {
+		t.Error("certificate does not match the private key")
+	}
+
+	// x/crypto/pkcs12把RSA私钥转为PKCS#1，这里直接检查PKCS#8编码的RSA私钥
+	pfx, err := ioutil.ReadFile("testdata/rsa_chain.pfx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, _, err := GetPrivateKeyAndChainFromPfx(pfx, testdataPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8, err := stdx509.MarshalPKCS8PrivateKey(rsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := parsePfxPrivateKey(pkcs8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k, ok := parsed.(*rsa.PrivateKey); !ok || !k.Equal(rsaKey) {
+		t.Errorf("parsePfxPrivateKey(PKCS#8 RSA) = %T", parsed)
+	}
+}
+
+func TestDecodeSm2RejectsUnexpectedOIDs(t *testing.T) {
+	data, err := readSm2File("testdata/cfca_v1.sm2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, tweak := range map[string]func(*smPdu){
+		"private key data OID": func(sm *smPdu) { sm.PrivContent.OID1 = asn1.ObjectIdentifier{1, 2, 156, 10197, 6, 1, 4, 2, 2} },
+		"cipher OID":           func(sm *smPdu) { sm.PrivContent.OID2 = asn1.ObjectIdentifier{1, 2, 156, 10197, 1, 105} },
+		"public key data OID":  func(sm *smPdu) { sm.PubContent.OID = oidSM4CBC },
+	} {
+		sm, err := parseSmPdu(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tweak(sm)
+		der, err := asn1.Marshal(*sm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := DecodeSm2(der, testdataPassword); err != ErrUnsupportedAlgorithm {
+			t.Errorf("%s: %v, want ErrUnsupportedAlgorithm", name, err)
+		}
+	}
+}
+
+func TestDecodeSm2PEM(t *testing.T) {
+	text, err := ioutil.ReadFile("testdata/cfca_v1.sm2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := readSm2File("testdata/cfca_v1.sm2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, in := range map[string][]byte{
+		"base64 text":      text,
+		"base64 + newline": append(text[:len(text):len(text)], '\n'),
+		"PEM block":        pem.EncodeToMemory(&pem.Block{Type: "SM2 ENVELOPE", Bytes: der}),
+	} {
+		priv, cert, err := DecodeSm2PEM(in, testdataPassword)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if priv.D.Cmp(testdataD) != 0 || cert.Subject.CommonName != "sm2 file fixture" {
+			t.Errorf("%s: D = %X, CN = %q", name, priv.D, cert.Subject.CommonName)
+		}
+	}
+	if _, _, err := DecodeSm2PEM([]byte("not base64!"), testdataPassword); !errors.Is(err, ErrMalformedData) {
+		t.Errorf("garbage: %v, want ErrMalformedData", err)
+	}
+}
+
+// 流式解码base64的readSm2File须与整体解码的结果一致，包括按行折断的文件
+func TestReadSm2FileStreaming(t *testing.T) {
+	text, err := ioutil.ReadFile("testdata/cfca_v1.sm2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(text)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wrapped bytes.Buffer
+	for s := strings.TrimSpace(string(text)); len(s) > 0; {
+		n := 64
+		if n > len(s) {
+			n = len(s)
+		}
+		wrapped.WriteString(s[:n] + "\r\n")
+		s = s[n:]
+	}
+
+	dir := t.TempDir()
+	for name, content := range map[string][]byte{"single line": text, "wrapped": wrapped.Bytes()} {
+		path := filepath.Join(dir, "key.sm2")
+		if err := ioutil.WriteFile(path, content, 0600); err != nil {
+			t.Fatal(err)
+		}
+		got, err := readSm2File(path)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: streamed DER differs from the buffered decode", name)
+		}
+		priv, cert, err := GetKeyAndCertFromSm2File(path, testdataPassword)
+		if err != nil || priv.D.Cmp(testdataD) != 0 || cert.Subject.CommonName != "sm2 file fixture" {
+			t.Errorf("%s: GetKeyAndCertFromSm2File: %v", name, err)
+		}
+	}
+
+	bad := filepath.Join(dir, "bad.sm2")
+	if err := ioutil.WriteFile(bad, []byte("@@@@"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readSm2File(bad); !errors.Is(err, ErrMalformedData) {
+		t.Errorf("invalid base64: %v, want ErrMalformedData", err)
+	}
+}
+
+// 只读证书时不解密私钥，因此不需要密码
+func TestGetCertificateFromSm2File(t *testing.T) {
+	cert, err := GetCertificateFromSm2File("testdata/cfca_v1.sm2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, want, err := GetKeyAndCertFromSm2File("testdata/cfca_v1.sm2", testdataPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cert.Equal(want) {
+		t.Error("certificate differs from the one DecodeSm2 returns")
+	}
+	if _, err := GetPrivateKeyFromSm2File("testdata/cfca_v1.sm2", ""); err != ErrIncorrectPassword {
+		t.Errorf("empty password: %v, want ErrIncorrectPassword", err)
+	}
+	if _, err := GetCertificateFromSm2File(filepath.Join(t.TempDir(), "missing.sm2")); !os.IsNotExist(err) {
+		t.Errorf("missing file: %v", err)
+	}
+}
+
+func TestDecodeSm2HugeLengthHeader(t *testing.T) {
+	// SEQUENCE头声明约2 GiB的内容，实际只有3个字节
+	hostile := []byte{0x30, 0x84, 0x7f, 0xff, 0xff, 0xff, 0x02, 0x01, 0x02}
+	start := time.Now()
+	if _, _, err := DecodeSm2(hostile, testdataPassword); !errors.Is(err, ErrMalformedData) {
+		t.Errorf("huge length header: %v, want ErrMalformedData", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("rejecting the header took %v", d)
+	}
+
+	oversized := make([]byte, MaxSm2DataSize+1)
+	oversized[0] = 0x30
+	if _, _, err := DecodeSm2(oversized, testdataPassword); err != ErrFileTooLarge {
+		t.Errorf("%d-byte input: %v, want ErrFileTooLarge", len(oversized), err)
+	}
+}
+
+func TestDecodeSm2Reader(t *testing.T) {
+	der, err := readSm2File("testdata/cfca_v1.sm2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantKey, wantCert, err := DecodeSm2(der, testdataPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	priv, cert, err := DecodeSm2Reader(bytes.NewReader(der), testdataPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if priv.D.Cmp(wantKey.D) != 0 || !cert.Equal(wantCert) {
+		t.Error("DecodeSm2Reader and DecodeSm2 disagree")
+	}
+	if _, _, err := DecodeSm2Reader(bytes.NewReader(der), "654321"); err != ErrIncorrectPassword {
+		t.Errorf("wrong password: %v, want ErrIncorrectPassword", err)
+	}
+	if _, _, err := DecodeSm2Reader(bytes.NewReader(make([]byte, MaxSm2DataSize+100)), testdataPassword); err != ErrFileTooLarge {
+		t.Errorf("oversized stream: %v, want ErrFileTooLarge", err)
+	}
+}
+
+func TestVerifyCertSignature(t *testing.T) {
+	newCert := func(subject string, pub *sm2.PublicKey, parent *x509.Certificate, signer *sm2.PrivateKey) *x509.Certificate {
+		tmpl := &x509.Certificate{
+			SerialNumber:          big.NewInt(time.Now().UnixNano()),
+			Subject:               pkix.Name{CommonName: subject},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(time.Hour),
+			BasicConstraintsValid: true,
+			IsCA:                  parent == nil,
+		}
+		if parent == nil {
+			parent = tmpl
+		}
+		der, err := x509.CreateCertificate(tmpl, parent, pub, signer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert
+	}
+	caKey, _ := sm2.GenerateKey(rand.Reader)
+	otherKey, _ := sm2.GenerateKey(rand.Reader)
+	leafKey, _ := sm2.GenerateKey(rand.Reader)
+	ca := newCert("verify CA", &caKey.PublicKey, nil, caKey)
+	leaf := newCert("verify leaf", &leafKey.PublicKey, ca, caKey)
+
+	if err := VerifyCertSignature(leaf, ca); err != nil {
+		t.Errorf("matched CA and leaf: %v", err)
+	}
+	// 主题相同但密钥不同，签名校验失败
+	if err := VerifyCertSignature(leaf, newCert("verify CA", &otherKey.PublicKey, nil, otherKey)); err == nil {
+		t.Error("leaf verified against a CA with the same subject but another key")
+	}
+	if err := VerifyCertSignature(leaf, newCert("other CA", &otherKey.PublicKey, nil, otherKey)); err == nil {
+		t.Error("leaf verified against a CA with a different subject")
+	}
+
+	pfx, err := ioutil.ReadFile("testdata/rsa_chain.pfx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, certs, err := GetPrivateKeyAndChainFromPfx(pfx, testdataPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyCertSignature(certs[0], certs[1]); err == nil || !strings.Contains(err.Error(), "SM2-with-SM3") {
+		t.Errorf("RSA chain: %v, want an SM2-with-SM3 error", err)
+	}
+	if err := VerifyCertSignature(leaf, certs[1]); err == nil || !strings.Contains(err.Error(), "SM2 public key") {
+		t.Errorf("RSA issuer: %v, want an SM2 public key error", err)
+	}
+}
@@ -1,25 +1,40 @@
 package pkcs12
 
 import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	stdx509 "crypto/x509"
 	"encoding/asn1"
 	"encoding/base64"
+	"encoding/binary"
 	"encoding/pem"
 	"envelope/sm2"
 	"envelope/sm3"
 	"envelope/sm4"
 	"envelope/x509"
 	"errors"
+	"fmt"
 	"golang.org/x/crypto/pkcs12"
+	"io"
 	"io/ioutil"
-	"log"
 	"math/big"
 	"os"
+	"strings"
 )
 
 type smPdu struct {
 	Version     int
 	PrivContent privateKeyContent
 	PubContent  publicKeyContent
+	KDF         kdfParams `asn1:"optional,explicit,tag:0"` // 仅版本2
+	MAC         []byte    `asn1:"optional,tag:1"`          // 仅版本2，见sm2FileMAC
+}
+
+// kdfParams 记录版本2文件中DeriveKey使用的盐值与迭代次数
+type kdfParams struct {
+	Salt       []byte
+	Iterations int
 }
 
 type privateKeyContent struct {
@@ -33,139 +48,612 @@ type publicKeyContent struct {
 	Content asn1.RawValue
 }
 
-func DecodeSm2(smData []byte, password string) (privateKey *sm2.PrivateKey, certificate *x509.Certificate, err error) {
+var (
+	// ErrIncorrectPassword is returned when the SM2 private key or PFX file
+	// cannot be decrypted with the supplied password (密码错误).
+	ErrIncorrectPassword = errors.New("pkcs12: incorrect password")
+	// ErrUnsupportedFormat is returned for file types and key encodings the
+	// package does not understand (文件格式错误).
+	ErrUnsupportedFormat = errors.New("pkcs12: unsupported format")
+	// ErrMalformedData is returned, usually wrapped with more detail, when an
+	// SM2 file, PFX file or certificate cannot be parsed.
+	ErrMalformedData = errors.New("pkcs12: malformed data")
+	// ErrTrailingData is returned when extra bytes follow an SM2 structure.
+	ErrTrailingData = errors.New("pkcs12: trailing data")
+	// ErrMalformedKey is returned when the encrypted SM2 private key blob is
+	// truncated or otherwise not a valid SM4-CBC ciphertext. It wraps
+	// ErrMalformedData.
+	ErrMalformedKey = fmt.Errorf("%w: SM2 private key", ErrMalformedData)
+	// ErrUnsupportedAlgorithm is returned when an SM2 file names algorithm
+	// OIDs other than SM2_Data and SM4_CBC. It wraps ErrUnsupportedFormat.
+	ErrUnsupportedAlgorithm = fmt.Errorf("%w: algorithm", ErrUnsupportedFormat)
+	// ErrFileTooLarge is returned when a key or certificate file is larger
+	// than MaxFileSize, or SM2 file data is larger than MaxSm2DataSize.
+	ErrFileTooLarge = errors.New("pkcs12: file too large")
+)
+
+const (
+	smPduVersionLegacy = 1 // 私钥由无盐的KDF(password)加密
+	smPduVersionPBKDF  = 2 // 私钥由DeriveKey(password, salt, iter)加密
+
+	// DefaultKDFIterations is the DeriveKey iteration count EncodeSm2 uses.
+	DefaultKDFIterations = 10000
+	kdfSaltSize          = 16
+	maxKDFIterations     = 10000000
+)
+
+// MaxFileSize bounds how many bytes the Get*File helpers read from disk.
+var MaxFileSize int64 = 1 << 20
+
+// MaxSm2DataSize bounds the DER input DecodeSm2 accepts, so hostile input is
+// rejected before it is parsed.
+var MaxSm2DataSize = 64 << 10
+
+// Sm2Vendor names the convention used to store the encrypted private key in
+// the OCTET STRING of an SM2 file.
+type Sm2Vendor int
+
+const (
+	// Sm2VendorAuto picks Sm2VendorCFCA or Sm2VendorBase64 from the length
+	// of the content; the two never overlap.
+	Sm2VendorAuto Sm2Vendor = iota
+	// Sm2VendorCFCA stores the raw SM4-CBC ciphertext of D: 48 bytes, or 32
+	// bytes from tools that drop a leading zero byte of D before padding.
+	// CFCA-issued .sm2 files and EncodeSm2 use it.
+	Sm2VendorCFCA
+	// Sm2VendorBase64 stores the standard base64 text of that ciphertext
+	// (64 or 44 characters), as some third-party tools write it.
+	Sm2VendorBase64
+)
+
+var (
+	oidSM2Data = asn1.ObjectIdentifier{1, 2, 156, 10197, 6, 1, 4, 2, 1}
+	oidSM4CBC  = asn1.ObjectIdentifier{1, 2, 156, 10197, 1, 104}
+)
+
+// parseSmPdu 解析SM2文件结构并校验算法OID，不涉及私钥解密
+func parseSmPdu(smData []byte) (*smPdu, error) {
+	if len(smData) > MaxSm2DataSize {
+		return nil, ErrFileTooLarge
+	}
 	sm := new(smPdu)
 	trailing, err := asn1.Unmarshal(smData, sm)
 	if err != nil {
-		return nil, nil, err
+		return nil, fmt.Errorf("%w: %w", ErrMalformedData, err)
 	}
 	if len(trailing) != 0 {
-		return nil, nil, errors.New("go-pkcs12: trailing data found")
+		return nil, fmt.Errorf("%w: %d bytes after SM2 structure", ErrTrailingData, len(trailing))
+	}
+	if !sm.PrivContent.OID1.Equal(oidSM2Data) || !sm.PrivContent.OID2.Equal(oidSM4CBC) || !sm.PubContent.OID.Equal(oidSM2Data) {
+		return nil, ErrUnsupportedAlgorithm
+	}
+	return sm, nil
+}
+
+// DecodeSm2 parses SM2 file data, detecting the private key encoding with
+// Sm2VendorAuto.
+func DecodeSm2(smData []byte, password string) (privateKey *sm2.PrivateKey, certificate *x509.Certificate, err error) {
+	return DecodeSm2WithVendor(smData, password, Sm2VendorAuto)
+}
+
+// DetectSm2Vendor reports which Sm2Vendor convention the private key in
+// smData follows, without decrypting it.
+func DetectSm2Vendor(smData []byte) (Sm2Vendor, error) {
+	sm, err := parseSmPdu(smData)
+	if err != nil {
+		return Sm2VendorAuto, err
 	}
+	return detectSm2Vendor(sm.PrivContent.Content.Bytes)
+}
 
-	dBytes := DecryptSm2Key(password, sm.PrivContent.Content.Bytes)
-	if len(dBytes) == 0 {
-		return nil, nil, errors.New("密码错误")
+// DecodeSm2WithVendor is like DecodeSm2 but requires the private key to be
+// stored as vendor specifies, for callers that know which tool wrote the file.
+func DecodeSm2WithVendor(smData []byte, password string, vendor Sm2Vendor) (*sm2.PrivateKey, *x509.Certificate, error) {
+	sm, err := parseSmPdu(smData)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	cer, err := x509.ParseCertificate(sm.PubContent.Content.Bytes)
+	var key, iv []byte
+	switch sm.Version {
+	case smPduVersionLegacy:
+		key, iv = DeriveSM4KeyIV([]byte(password))
+	case smPduVersionPBKDF:
+		if len(sm.KDF.Salt) == 0 || sm.KDF.Iterations <= 0 || sm.KDF.Iterations > maxKDFIterations {
+			return nil, nil, fmt.Errorf("%w: invalid key derivation parameters", ErrMalformedData)
+		}
+		h := DeriveKey([]byte(password), sm.KDF.Salt, sm.KDF.Iterations, 64)
+		key, iv = splitKeyIV(h)
+		// 带MAC的文件先以常量时间比较校验值判断密码，不依赖解密结果
+		if len(sm.MAC) != 0 {
+			tag := sm2FileMAC(h[32:], sm.PrivContent.Content.Bytes, sm.PubContent.Content.Bytes)
+			if subtle.ConstantTimeCompare(tag, sm.MAC) != 1 {
+				return nil, nil, ErrIncorrectPassword
+			}
+		}
+	default:
+		return nil, nil, fmt.Errorf("%w: SM2 file version %d", ErrUnsupportedFormat, sm.Version)
+	}
+	dBytes, err := decryptSm2Key(key, iv, sm.PrivContent.Content.Bytes, vendor)
 	if err != nil {
-		log.Fatal(err)
 		return nil, nil, err
 	}
 
-	//pub := cer.PublicKey.(*ecdsa.PublicKey)
-	pub := cer.PublicKey.(*sm2.PublicKey)
+	cer, err := x509.ParseCertificate(sm.PubContent.Content.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrMalformedData, err)
+	}
+
+	pub, ok := cer.PublicKey.(*sm2.PublicKey)
+	if !ok {
+		return nil, nil, errors.New("pkcs12: certificate does not contain an SM2 public key")
+	}
+	if !pub.IsValid() {
+		return nil, nil, errors.New("pkcs12: certificate contains an invalid SM2 public key")
+	}
 	priv := &sm2.PrivateKey{
 		PublicKey: *pub,
 		D:         new(big.Int).SetBytes(dBytes),
 	}
+	// 填充校验有约1/256的概率误判，再用证书公钥确认密码正确
+	x, y := pub.Curve.ScalarBaseMult(dBytes)
+	if x.Cmp(pub.X) != 0 || y.Cmp(pub.Y) != 0 {
+		return nil, nil, ErrIncorrectPassword
+	}
 	return priv, cer, nil
 }
 
+/*
+	生成sm2数字信封，输出的DER数据可由DecodeSm2使用相同的密码解析
+	输出为版本2格式：私钥由DeriveKey(password, 随机盐, DefaultKDFIterations)加密，
+	并附带HMAC-SM3校验值用于判断密码是否正确，仅支持版本1的旧实现无法读取
+*/
+func EncodeSm2(priv *sm2.PrivateKey, cert *x509.Certificate, password string) ([]byte, error) {
+	if priv == nil || priv.D == nil {
+		return nil, errors.New("pkcs12: missing SM2 private key")
+	}
+	if cert == nil || len(cert.Raw) == 0 {
+		return nil, errors.New("pkcs12: missing certificate DER")
+	}
+
+	salt := make([]byte, kdfSaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	h := DeriveKey([]byte(password), salt, DefaultKDFIterations, 64)
+	key, iv := splitKeyIV(h)
+	encryptedKey, err := encryptSm2Key(key, iv, priv.D)
+	if err != nil {
+		return nil, err
+	}
+
+	sm := smPdu{
+		Version: smPduVersionPBKDF,
+		PrivContent: privateKeyContent{
+			OID1:    oidSM2Data,
+			OID2:    oidSM4CBC,
+			Content: asn1.RawValue{Tag: asn1.TagOctetString, Bytes: encryptedKey},
+		},
+		PubContent: publicKeyContent{
+			OID:     oidSM2Data,
+			Content: asn1.RawValue{Tag: asn1.TagOctetString, Bytes: cert.Raw},
+		},
+		KDF: kdfParams{Salt: salt, Iterations: DefaultKDFIterations},
+		MAC: sm2FileMAC(h[32:], encryptedKey, cert.Raw),
+	}
+	return asn1.Marshal(sm)
+}
+
+/*
+	生成.sm2文件内容 (base64编码的EncodeSm2输出)，可由GetPrivateKeyFromSm2File读取
+	password为空时，私钥使用由空密码导出的SM4密钥加密，读取时同样传入空密码即可
+*/
+func GenerateSm2File(priv *sm2.PrivateKey, cert *x509.Certificate, password string) ([]byte, error) {
+	der, err := EncodeSm2(priv, cert, password)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, base64.StdEncoding.EncodedLen(len(der)))
+	base64.StdEncoding.Encode(out, der)
+	return out, nil
+}
+
 /**
 Key Derivation function (密钥导出函数)
-	将密钥扩展到所需长度的密钥
+	将密钥扩展到所需长度的密钥，GB/T 32918.4 计数器模式:
+	K = SM3(Z || ct1) || SM3(Z || ct2) || ... 截取前keyLen字节
 **/
-func KDF(z []byte) []byte {
-	ct := []byte{0, 0, 0, 1}
-	sm3 := sm3.New()
-	sm3.Write(z)
-	sm3.Write(ct)
-	h := sm3.Sum(nil)
-	return h
+func KDF(z []byte, keyLen int) []byte {
+	if keyLen <= 0 {
+		return []byte{}
+	}
+	out := make([]byte, 0, (keyLen+sm3.Size-1)/sm3.Size*sm3.Size)
+	ct := make([]byte, 4)
+	h := sm3.New()
+	for i := uint32(1); len(out) < keyLen; i++ {
+		binary.BigEndian.PutUint32(ct, i)
+		h.Reset()
+		h.Write(z)
+		h.Write(ct)
+		out = append(out, h.Sum(nil)...)
+	}
+	return out[:keyLen]
+}
+
+// DeriveSM4KeyIV derives an SM4 IV and key from secret with KDF(secret, 32):
+// the first 16 bytes are the IV and the last 16 the key. Version 1 SM2 files
+// encrypt the private key under DeriveSM4KeyIV(password).
+func DeriveSM4KeyIV(secret []byte) (key, iv []byte) {
+	return splitKeyIV(KDF(secret, 32))
+}
+
+// splitKeyIV 按SM2文件的约定拆分派生结果：前16字节为IV，后16字节为SM4密钥
+func splitKeyIV(h []byte) (key, iv []byte) {
+	return h[16:32], h[:16]
+}
+
+// sm2FileMAC 计算版本2文件的校验值 HMAC-SM3(macKey, 私钥密文 || 证书)
+// macKey为DeriveKey输出的第33至64字节，前32字节仍用作SM4的IV与密钥
+func sm2FileMAC(macKey, encryptedKey, cert []byte) []byte {
+	mac := sm3.NewHMAC(macKey)
+	mac.Write(encryptedKey)
+	mac.Write(cert)
+	return mac.Sum(nil)
+}
+
+// DeriveKey derives keyLen bytes from password with PBKDF2 (RFC 8018) using
+// HMAC-SM3 as the pseudorandom function.
+func DeriveKey(password, salt []byte, iter, keyLen int) []byte {
+	prf := sm3.NewHMAC(password)
+	numBlocks := (keyLen + sm3.Size - 1) / sm3.Size
+	dk := make([]byte, 0, numBlocks*sm3.Size)
+	ct := make([]byte, 4)
+	for block := 1; block <= numBlocks; block++ {
+		binary.BigEndian.PutUint32(ct, uint32(block))
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write(ct)
+		u := prf.Sum(nil)
+		t := append([]byte(nil), u...)
+		for n := 2; n <= iter; n++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(nil)
+			for i := range t {
+				t[i] ^= u[i]
+			}
+		}
+		dk = append(dk, t...)
+	}
+	return dk[:keyLen]
 }
 
 /*
-	解密sm2私钥
+	加密sm2私钥，D左补零至32字节后使用SM4-CBC加密
+	SM4密钥由无盐的KDF(password)导出，与版本1文件兼容
 */
-func DecryptSm2Key(password string, encryptedData []byte) []byte {
-	if len(encryptedData) >= 32 && len(encryptedData) <= 64 {
-		encoding := make([]byte, len(encryptedData), len(encryptedData))
-		if len(encryptedData) != 32 && len(encryptedData) != 48 {
-			base64.StdEncoding.Decode(encoding, encryptedData)
-		}
-		encoding = encryptedData
+func EncryptSm2Key(password string, d *big.Int) ([]byte, error) {
+	key, iv := DeriveSM4KeyIV([]byte(password))
+	return encryptSm2Key(key, iv, d)
+}
 
-		h := KDF([]byte(password))
+// encryptSm2Key 使用SM4-CBC加密D
+func encryptSm2Key(key, iv []byte, d *big.Int) ([]byte, error) {
+	dBytes := make([]byte, 32)
+	if len(d.Bytes()) > len(dBytes) {
+		return nil, errors.New("pkcs12: invalid SM2 private key")
+	}
+	d.FillBytes(dBytes)
 
-		iv := h[:16]
-		key := h[16:]
+	sm4, err := sm4.Init(iv, key)
+	if err != nil {
+		return nil, err
+	}
+	return sm4.Sm4Cbc(dBytes, true)
+}
+
+/*
+	解密sm2私钥，encryptedData可以是原始密文，也可以是其base64编码，见Sm2Vendor
+*/
+func DecryptSm2Key(password string, encryptedData []byte) ([]byte, error) {
+	key, iv := DeriveSM4KeyIV([]byte(password))
+	return decryptSm2Key(key, iv, encryptedData, Sm2VendorAuto)
+}
 
-		sm4 := sm4.Init(iv, key)
-		out, err := sm4.Sm4Cbc(encoding, false)
+// detectSm2Vendor 按长度区分原始密文(32/48字节)与其base64编码(44/64字符)
+func detectSm2Vendor(content []byte) (Sm2Vendor, error) {
+	switch len(content) {
+	case 32, 48:
+		return Sm2VendorCFCA, nil
+	case 44, 64:
+		return Sm2VendorBase64, nil
+	}
+	return Sm2VendorAuto, ErrMalformedKey
+}
+
+// decryptSm2Key 使用encryptSm2Key相同的key与iv解密，密文按vendor约定的方式存放
+func decryptSm2Key(key, iv, encryptedData []byte, vendor Sm2Vendor) ([]byte, error) {
+	if vendor == Sm2VendorAuto {
+		var err error
+		if vendor, err = detectSm2Vendor(encryptedData); err != nil {
+			return nil, err
+		}
+	}
+	var encoding []byte
+	switch vendor {
+	case Sm2VendorCFCA:
+		encoding = encryptedData
+	case Sm2VendorBase64:
+		decoded, err := base64.StdEncoding.Strict().DecodeString(string(encryptedData))
 		if err != nil {
-			log.Fatal(err)
+			return nil, ErrMalformedKey
 		}
+		encoding = decoded
+	default:
+		return nil, fmt.Errorf("%w: SM2 vendor %d", ErrUnsupportedFormat, int(vendor))
+	}
+	if len(encoding) != 32 && len(encoding) != 48 {
+		return nil, ErrMalformedKey
+	}
 
-		d := new(big.Int)
-		d.SetBytes(out)
-		return out
+	sm4, err := sm4.Init(iv, key)
+	if err != nil {
+		return nil, err
+	}
+	out, err := sm4.Sm4Cbc(encoding, false)
+	if err != nil {
+		// 长度已校验，解密后填充无效说明密码错误
+		return nil, ErrIncorrectPassword
+	}
+	if len(out) == 0 || len(out) > 32 {
+		return nil, ErrIncorrectPassword
 	}
-	return nil
+	return out, nil
 }
 
 func GetPrivateKeyFromSm2File(file, password string) (*sm2.PrivateKey, error) {
-	open, err := os.Open(file)
+	privateKey, _, err := GetKeyAndCertFromSm2File(file, password)
+	return privateKey, err
+}
+
+// GetKeyAndCertFromSm2File reads a base64 SM2 file and returns both the
+// private key and the certificate stored with it.
+func GetKeyAndCertFromSm2File(file, password string) (*sm2.PrivateKey, *x509.Certificate, error) {
+	b, err := readSm2File(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	return DecodeSm2(b, password)
+}
+
+// GetCertificateFromSm2File returns the certificate stored in a base64 SM2
+// file. It does not decrypt the private key, so no password is needed.
+func GetCertificateFromSm2File(file string) (*x509.Certificate, error) {
+	b, err := readSm2File(file)
 	if err != nil {
 		return nil, err
 	}
-	smData, err := ioutil.ReadAll(open)
+	sm, err := parseSmPdu(b)
 	if err != nil {
 		return nil, err
 	}
-	b, err := base64.StdEncoding.DecodeString(string(smData))
+	cert, err := x509.ParseCertificate(sm.PubContent.Content.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformedData, err)
+	}
+	return cert, nil
+}
+
+// readSm2File 读取base64编码的SM2文件并返回DER
+func readSm2File(file string) ([]byte, error) {
+	open, err := os.Open(file)
 	if err != nil {
 		return nil, err
 	}
+	defer open.Close()
 
-	privateKey, _, err := DecodeSm2(b, password)
-	return privateKey, err
+	// 边读边解码base64，内存中只保留解码后的DER
+	lr := &io.LimitedReader{R: open, N: MaxFileSize + 1}
+	b, err := ioutil.ReadAll(base64.NewDecoder(base64.StdEncoding, lr))
+	if err != nil {
+		// 解码出错时读完剩余部分，超长文件仍报告ErrFileTooLarge
+		_, _ = io.Copy(ioutil.Discard, lr)
+	}
+	if lr.N == 0 {
+		return nil, ErrFileTooLarge
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformedData, err)
+	}
+	return b, nil
+}
+
+// DecodeSm2Reader is DecodeSm2 for DER read from r. At most MaxSm2DataSize
+// bytes are buffered; longer input fails with ErrFileTooLarge.
+func DecodeSm2Reader(r io.Reader, password string) (*sm2.PrivateKey, *x509.Certificate, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, int64(MaxSm2DataSize)+1))
+	if err != nil {
+		return nil, nil, err
+	}
+	return DecodeSm2(data, password)
 }
 
+// DecodeSm2PEM decodes SM2 file data held in memory. pemBytes is either a PEM
+// block (of any type) wrapping the DER output of EncodeSm2, or the bare base64
+// text found in .sm2 files.
+func DecodeSm2PEM(pemBytes []byte, password string) (*sm2.PrivateKey, *x509.Certificate, error) {
+	if block, _ := pem.Decode(pemBytes); block != nil {
+		return DecodeSm2(block.Bytes, password)
+	}
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(pemBytes)))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrMalformedData, err)
+	}
+	return DecodeSm2(b, password)
+}
+
+// GetPrivateKeyFromBytes parses a private key file by extension: ".sm2" for
+// base64 SM2 files and ".pfx" or ".p12" for PKCS#12. The comparison ignores
+// case.
 func GetPrivateKeyFromBytes(data []byte, ext, password string) (interface{}, error) {
-	if ext == ".sm2" {
+	switch strings.ToLower(ext) {
+	case ".sm2":
 		b, err := base64.StdEncoding.DecodeString(string(data))
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("%w: %w", ErrMalformedData, err)
 		}
 		privateKey, _, err := DecodeSm2(b, password)
 		return privateKey, err
-	} else if ext == ".pfx" {
-		blocks, err := pkcs12.ToPEM(data, password)
-		if err != nil {
-			if errors.Is(err, pkcs12.ErrIncorrectPassword) {
-				return nil, errors.New("密码错误")
-			}
-			log.Println(err)
-			return nil, err
+	case ".pfx", ".p12":
+		privateKey, _, err := GetPrivateKeyAndChainFromPfx(data, password)
+		return privateKey, err
+	default:
+		return nil, fmt.Errorf("%w: file extension %q", ErrUnsupportedFormat, ext)
+	}
+}
+
+// GetPrivateKeyFromBytesAuto is like GetPrivateKeyFromBytes but detects the
+// format from the data instead of a file extension. data may be DER or base64
+// text; it is tried as an SM2 file first and then as PKCS#12.
+func GetPrivateKeyFromBytesAuto(data []byte, password string) (interface{}, error) {
+	der := data
+	if len(data) == 0 || data[0] != 0x30 {
+		b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(b) == 0 || b[0] != 0x30 {
+			return nil, fmt.Errorf("%w: unrecognized key data", ErrUnsupportedFormat)
 		}
+		der = b
+	}
 
-		privateKey, err := x509.ParsePKCS1PrivateKey(blocks[0].Bytes)
-		if err != nil {
-			return nil, err
+	privateKey, _, err := DecodeSm2(der, password)
+	if err == nil || errors.Is(err, ErrIncorrectPassword) {
+		return privateKey, err
+	}
+	key, _, err := GetPrivateKeyAndChainFromPfx(der, password)
+	if err == nil || errors.Is(err, ErrIncorrectPassword) {
+		return key, err
+	}
+	return nil, fmt.Errorf("%w: neither an SM2 nor a PKCS#12 file", ErrUnsupportedFormat)
+}
+
+
+/*
+	解析pfx文件，返回私钥及其中的全部证书
+	私钥可以是RSA、ECDSA、Ed25519或SM2，由调用方按类型判断
+*/
+func GetPrivateKeyAndChainFromPfx(data []byte, password string) (interface{}, []*x509.Certificate, error) {
+	blocks, err := pkcs12.ToPEM(data, password)
+	if err != nil {
+		if errors.Is(err, pkcs12.ErrIncorrectPassword) {
+			return nil, nil, ErrIncorrectPassword
 		}
-		return privateKey, nil
-	} else {
-		return nil, errors.New("文件格式错误")
+		// x/crypto/pkcs12无法解析SM2私钥，改用pfxsm2.go中的解码
+		if key, certs, sm2Err := decodeSm2Pfx(data, password); sm2Err == nil {
+			return key, certs, nil
+		}
+		return nil, nil, fmt.Errorf("%w: %w", ErrMalformedData, err)
 	}
+
+	var privateKey interface{}
+	var certs []*x509.Certificate
+	for _, block := range blocks {
+		switch block.Type {
+		case "PRIVATE KEY":
+			if privateKey != nil {
+				return nil, nil, fmt.Errorf("%w: multiple private keys found", ErrMalformedData)
+			}
+			privateKey, err = parsePfxPrivateKey(block.Bytes)
+			if err != nil {
+				return nil, nil, err
+			}
+		case "CERTIFICATE":
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%w: %w", ErrMalformedData, err)
+			}
+			certs = append(certs, cert)
+		}
+	}
+	if privateKey == nil {
+		return nil, nil, fmt.Errorf("%w: no private key found", ErrMalformedData)
+	}
+	return privateKey, certs, nil
 }
 
+// parsePfxPrivateKey 依次尝试PKCS#1、PKCS#8 (RSA/ECDSA/Ed25519)、SM2 PKCS#8
+// 与SEC 1格式。x/crypto/pkcs12将ECDSA私钥以SEC 1编码放在"PRIVATE KEY"块中
+func parsePfxPrivateKey(der []byte) (interface{}, error) {
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := stdx509.ParsePKCS8PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS8UnecryptedPrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := stdx509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("%w: PFX private key", ErrUnsupportedFormat)
+}
 
 func GetPublicKeyFromSM2File(file string) (*sm2.PublicKey,error) {
 	open, err := os.Open(file)
 	if err != nil {
 		return nil, err
 	}
+	defer open.Close()
 
-	cerData, err := ioutil.ReadAll(open)
+	cerData, err := readLimited(open)
+	if err != nil {
+		return nil, err
+	}
 	block, _ := pem.Decode(cerData)
+	if block == nil {
+		return nil, fmt.Errorf("%w: not a PEM certificate", ErrMalformedData)
+	}
 
 	certificate, err := x509.ParseCertificate(block.Bytes)
 	if err != nil {
 		return nil,err
 	}
 
-	publicKey := certificate.PublicKey.(*sm2.PublicKey)
+	publicKey, ok := certificate.PublicKey.(*sm2.PublicKey)
+	if !ok {
+		return nil, errors.New("pkcs12: certificate does not contain an SM2 public key")
+	}
 	return publicKey,nil
-}
\ No newline at end of file
+}
+
+/*
+	校验cert是否由issuer使用SM2-with-SM3签发，在信任叶子证书公钥之前调用
+*/
+func VerifyCertSignature(cert, issuer *x509.Certificate) error {
+	if cert == nil || issuer == nil {
+		return errors.New("pkcs12: missing certificate")
+	}
+	if cert.SignatureAlgorithm != x509.SM2WithSM3 {
+		return errors.New("pkcs12: certificate is not signed with SM2-with-SM3")
+	}
+	if _, ok := issuer.PublicKey.(*sm2.PublicKey); !ok {
+		return errors.New("pkcs12: issuer certificate does not contain an SM2 public key")
+	}
+	if !bytes.Equal(cert.RawIssuer, issuer.RawSubject) {
+		return errors.New("pkcs12: certificate issuer does not match issuer subject")
+	}
+	return cert.CheckSignatureFrom(issuer)
+}
+
+// readLimited reads r to EOF, failing with ErrFileTooLarge once more than
+// MaxFileSize bytes have been seen.
+func readLimited(r io.Reader) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, MaxFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > MaxFileSize {
+		return nil, ErrFileTooLarge
+	}
+	return data, nil
+}
@@ -0,0 +1,123 @@
+package sm2
+
+// SM2 密钥交换协议 (GM/T 0003.3)
+import (
+	"envelope/sm3"
+	"errors"
+	"math/big"
+)
+
+// KeyExchangeA runs the initiator (A) side of the SM2 key exchange.
+//
+// klen is the length in bytes of the shared key. ida and idb are the user IDs
+// of A and B (nil selects the default ID). priA is A's static key, pubB is B's
+// static public key, rpri is the ephemeral key A generated and sent to B as
+// rpri.PublicKey, and rpubB is the ephemeral public key received from B.
+//
+// It returns the shared key k, s1 which must equal the SB tag sent by B, and
+// s2 (SA) which A sends to B for confirmation.
+func KeyExchangeA(klen int, ida, idb []byte, priA *PrivateKey, pubB *PublicKey, rpri *PrivateKey, rpubB *PublicKey) (k, s1, s2 []byte, err error) {
+	return keyExchange(klen, ida, idb, priA, pubB, rpri, rpubB, true)
+}
+
+// KeyExchangeB runs the responder (B) side of the SM2 key exchange.
+//
+// The arguments mirror KeyExchangeA: priB is B's static key, pubA is A's
+// static public key, rpri is B's ephemeral key and rpubA is the ephemeral
+// public key received from A.
+//
+// It returns the shared key k, s1 (SB) which B sends to A, and s2 which must
+// equal the SA tag sent back by A.
+func KeyExchangeB(klen int, ida, idb []byte, priB *PrivateKey, pubA *PublicKey, rpri *PrivateKey, rpubA *PublicKey) (k, s1, s2 []byte, err error) {
+	return keyExchange(klen, ida, idb, priB, pubA, rpri, rpubA, false)
+}
+
+func keyExchange(klen int, ida, idb []byte, pri *PrivateKey, pub *PublicKey, rpri *PrivateKey, rpub *PublicKey, thisIsA bool) (k, s1, s2 []byte, err error) {
+	if klen <= 0 {
+		return nil, nil, nil, errors.New("SM2: invalid key length")
+	}
+	if pri == nil || pub == nil || rpri == nil || rpub == nil {
+		return nil, nil, nil, errors.New("SM2: missing key exchange parameter")
+	}
+	curve := pri.Curve
+	N := curve.Params().N
+	if !pub.IsValid() || !rpub.IsValid() {
+		return nil, nil, nil, errors.New("SM2: peer public key is not on curve")
+	}
+	if len(ida) == 0 {
+		ida = default_uid
+	}
+	if len(idb) == 0 {
+		idb = default_uid
+	}
+	var za, zb []byte
+	if thisIsA {
+		za, err = ZA(&pri.PublicKey, ida)
+		if err == nil {
+			zb, err = ZA(pub, idb)
+		}
+	} else {
+		za, err = ZA(pub, ida)
+		if err == nil {
+			zb, err = ZA(&pri.PublicKey, idb)
+		}
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// t = (d + x̄·r) mod n
+	x1 := reduceX(rpri.PublicKey.X)
+	t := new(big.Int).Mul(x1, rpri.D)
+	t.Add(t, pri.D)
+	t.Mod(t, N)
+
+	// U = [h·t](P + [x̄']R)，SM2 曲线余因子 h = 1
+	x2 := reduceX(rpub.X)
+	tx, ty := curve.ScalarMult(rpub.X, rpub.Y, x2.Bytes())
+	tx, ty = curve.Add(pub.X, pub.Y, tx, ty)
+	ux, uy := curve.ScalarMult(tx, ty, t.Bytes())
+	if ux.Sign() == 0 && uy.Sign() == 0 {
+		return nil, nil, nil, errors.New("SM2: key exchange point at infinity")
+	}
+
+	xU, yU := toBytes(curve, ux), toBytes(curve, uy)
+	k, ok := kdf(klen, xU, yU, za, zb)
+	if !ok {
+		return nil, nil, nil, errors.New("SM2: key exchange derived zero key")
+	}
+
+	// 确认值按 A、B 的临时公钥顺序计算
+	ra, rb := &rpri.PublicKey, rpub
+	if !thisIsA {
+		ra, rb = rpub, &rpri.PublicKey
+	}
+	h := sm3.New()
+	h.Write(xU)
+	h.Write(za)
+	h.Write(zb)
+	h.Write(toBytes(curve, ra.X))
+	h.Write(toBytes(curve, ra.Y))
+	h.Write(toBytes(curve, rb.X))
+	h.Write(toBytes(curve, rb.Y))
+	inner := h.Sum(nil)
+
+	// 双方计算的 U 与 V 相同，因此 SB 与 SA 的计算对两端一致
+	return k, confirmTag(0x02, yU, inner), confirmTag(0x03, yU, inner), nil
+}
+
+// reduceX 计算 x̄ = 2^w + (x & (2^w - 1))，w = ⌈⌈log2(n)⌉/2⌉ - 1 = 127
+func reduceX(x *big.Int) *big.Int {
+	w := new(big.Int).Lsh(one, 127)
+	r := new(big.Int).Sub(w, one)
+	r.And(r, x)
+	return r.Add(r, w)
+}
+
+func confirmTag(prefix byte, y, inner []byte) []byte {
+	h := sm3.New()
+	h.Write([]byte{prefix})
+	h.Write(y)
+	h.Write(inner)
+	return h.Sum(nil)
+}
@@ -0,0 +1,5 @@
+//go:build !race
+
+package sm2
+
+const raceEnabled = false
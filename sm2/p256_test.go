@@ -0,0 +1,65 @@
+package sm2
+
+import (
+	"bytes"
+	"crypto/rand"
+	"math/big"
+	"testing"
+)
+
+// 与elliptic.CurveParams的通用实现对比，覆盖边界标量与超过32字节的标量
+func TestScalarMultMatchesGeneric(t *testing.T) {
+	curve := P256Sm2()
+	params := curve.Params()
+	n := params.N
+	one := big.NewInt(1)
+	px, py := params.ScalarBaseMult(big.NewInt(7).Bytes())
+
+	scalars := [][]byte{
+		{0},
+		{1},
+		new(big.Int).Sub(n, one).Bytes(),
+		n.Bytes(),
+		new(big.Int).Add(n, one).Bytes(),
+		bytes.Repeat([]byte{0xff}, 32),
+		append(bytes.Repeat([]byte{0xab}, 8), n.Bytes()...),       // 40字节
+		new(big.Int).Lsh(one, 255).Bytes(),                        // 汉明重量1
+		new(big.Int).Sub(new(big.Int).Lsh(one, 255), one).Bytes(), // 汉明重量255
+	}
+	for i := 0; i < 32; i++ {
+		k := make([]byte, 32)
+		rand.Read(k)
+		scalars = append(scalars, k)
+	}
+	for _, k := range scalars {
+		gx, gy := params.ScalarMult(px, py, k)
+		x, y := curve.ScalarMult(px, py, k)
+		if x.Cmp(gx) != 0 || y.Cmp(gy) != 0 {
+			t.Errorf("ScalarMult(%x) = (%x, %x), want (%x, %x)", k, x, y, gx, gy)
+		}
+		gx, gy = params.ScalarBaseMult(k)
+		x, y = curve.ScalarBaseMult(k)
+		if x.Cmp(gx) != 0 || y.Cmp(gy) != 0 {
+			t.Errorf("ScalarBaseMult(%x) = (%x, %x), want (%x, %x)", k, x, y, gx, gy)
+		}
+	}
+}
+
+func BenchmarkScalarMult(b *testing.B) {
+	curve := P256Sm2()
+	px, py := curve.ScalarBaseMult(big.NewInt(7).Bytes())
+	one := big.NewInt(1)
+	for _, bc := range []struct {
+		name string
+		k    []byte
+	}{
+		{"LowWeight", new(big.Int).Lsh(one, 255).Bytes()},
+		{"HighWeight", new(big.Int).Sub(curve.Params().N, big.NewInt(2)).Bytes()},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				curve.ScalarMult(px, py, bc.k)
+			}
+		})
+	}
+}
@@ -0,0 +1,6 @@
+//go:build race
+
+package sm2
+
+// race检测会增加内存分配，分配次数测试在-race下跳过
+const raceEnabled = true
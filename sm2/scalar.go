@@ -0,0 +1,102 @@
+package sm2
+
+import (
+	"math/big"
+	"math/bits"
+)
+
+// 模n(SM2曲线的阶)上的定长运算，4个64位小端limb，用于与私钥相关的求逆
+
+type orderElement [4]uint64
+
+var (
+	orderN = orderElement{0x53bbf40939d54123, 0x7203df6b21c6052b, 0xffffffffffffffff, 0xfffffffeffffffff}
+	// orderRR = 2^512 mod n，用于转换到Montgomery形式
+	orderRR = orderElement{0x901192af7c114f20, 0x3464504ade6fa2fa, 0x620fc84c3affe0d4, 0x1eb5e412a22b3d3b}
+	// orderN0Inv = -n^-1 mod 2^64
+	orderN0Inv uint64 = 0x327f9e8872350975
+)
+
+// orderMontMul sets z = x*y*2^-256 mod n. x and y must be less than n. The
+// sequence of operations does not depend on the values.
+func orderMontMul(z, x, y *orderElement) {
+	var t [6]uint64
+	for i := 0; i < 4; i++ {
+		var c uint64
+		for j := 0; j < 4; j++ {
+			hi, lo := bits.Mul64(x[j], y[i])
+			var c1, c2 uint64
+			lo, c1 = bits.Add64(lo, t[j], 0)
+			lo, c2 = bits.Add64(lo, c, 0)
+			t[j], c = lo, hi+c1+c2
+		}
+		var c1 uint64
+		t[4], c1 = bits.Add64(t[4], c, 0)
+		t[5] = c1
+
+		m := t[0] * orderN0Inv
+		hi, lo := bits.Mul64(m, orderN[0])
+		_, c1 = bits.Add64(lo, t[0], 0)
+		c = hi + c1
+		for j := 1; j < 4; j++ {
+			hi, lo := bits.Mul64(m, orderN[j])
+			var c2 uint64
+			lo, c1 = bits.Add64(lo, t[j], 0)
+			lo, c2 = bits.Add64(lo, c, 0)
+			t[j-1], c = lo, hi+c1+c2
+		}
+		t[3], c1 = bits.Add64(t[4], c, 0)
+		t[4] = t[5] + c1
+	}
+
+	// t < 2n，按常量时间选择t或t-n
+	var d orderElement
+	var b uint64
+	d[0], b = bits.Sub64(t[0], orderN[0], 0)
+	d[1], b = bits.Sub64(t[1], orderN[1], b)
+	d[2], b = bits.Sub64(t[2], orderN[2], b)
+	d[3], b = bits.Sub64(t[3], orderN[3], b)
+	mask := -(t[4] | (b ^ 1))
+	for i := range z {
+		z[i] = d[i]&mask | t[i]&^mask
+	}
+}
+
+// orderInverse returns k^-1 mod n for 0 < k < n by Fermat's little theorem,
+// k^(n-2) mod n, in fixed-width Montgomery arithmetic. The exponent is
+// public, so the square-and-multiply sequence is the same for every k,
+// unlike big.Int.ModInverse whose running time depends on k.
+func orderInverse(k *big.Int) *big.Int {
+	var buf [32]byte
+	k.FillBytes(buf[:])
+	var x orderElement
+	for i := range x {
+		for j := 0; j < 8; j++ {
+			x[i] |= uint64(buf[31-8*i-j]) << (8 * j)
+		}
+	}
+	orderMontMul(&x, &x, &orderRR)
+
+	// 从最高位开始处理指数n-2
+	e := orderN
+	e[0] -= 2
+	r := orderElement{1}
+	orderMontMul(&r, &r, &orderRR)
+	for i := 3; i >= 0; i-- {
+		for j := 63; j >= 0; j-- {
+			orderMontMul(&r, &r, &r)
+			if e[i]>>uint(j)&1 == 1 {
+				orderMontMul(&r, &r, &x)
+			}
+		}
+	}
+
+	one := orderElement{1}
+	orderMontMul(&r, &r, &one)
+	for i := range r {
+		for j := 0; j < 8; j++ {
+			buf[31-8*i-j] = byte(r[i] >> (8 * j))
+		}
+	}
+	return new(big.Int).SetBytes(buf[:])
+}
@@ -0,0 +1,59 @@
+package sm2
+
+import (
+	"crypto/rand"
+	"math/big"
+	"testing"
+)
+
+func TestOrderInverse(t *testing.T) {
+	N := P256Sm2().Params().N
+	one := big.NewInt(1)
+	ks := []*big.Int{
+		one,
+		big.NewInt(2),
+		new(big.Int).Sub(N, one),
+		new(big.Int).Sub(N, big.NewInt(2)),
+	}
+	for i := uint(1); i < 256; i += 7 {
+		ks = append(ks, new(big.Int).Lsh(one, i))
+	}
+	for i := 0; i < 500; i++ {
+		k, err := rand.Int(rand.Reader, N)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if k.Sign() != 0 {
+			ks = append(ks, k)
+		}
+	}
+	for _, k := range ks {
+		if got, want := orderInverse(k), new(big.Int).ModInverse(k, N); got.Cmp(want) != 0 {
+			t.Errorf("orderInverse(%x) = %x, want %x", k, got, want)
+		}
+	}
+}
+
+// d = n-1时1+d ≡ 0，没有逆元，签名必须报错
+func TestSignRejectsNonInvertibleKey(t *testing.T) {
+	priv := vectorKey()
+	bad := &PrivateKey{PublicKey: priv.PublicKey, D: new(big.Int).Sub(priv.Curve.Params().N, big.NewInt(1))}
+	if _, _, err := bad.SignWithUserID(rand.Reader, []byte("msg"), nil); err == nil {
+		t.Error("signed with d = n-1")
+	}
+}
+
+func BenchmarkOrderInverse(b *testing.B) {
+	N := P256Sm2().Params().N
+	k, _ := rand.Int(rand.Reader, N)
+	b.Run("Fermat", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			orderInverse(k)
+		}
+	})
+	b.Run("ModInverse", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			new(big.Int).ModInverse(k, N)
+		}
+	})
+}
@@ -20,8 +20,11 @@ import (
 	"crypto"
 	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/asn1"
 	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
 	"envelope/sm3"
 	"errors"
 	"fmt"
@@ -29,12 +32,19 @@ import (
 	xasn1 "golang.org/x/crypto/cryptobyte/asn1"
 	"io"
 	"math/big"
+	"sync"
 )
 
 var (
 	default_uid = []byte{0x31, 0x32, 0x33, 0x34, 0x35, 0x36, 0x37, 0x38, 0x31, 0x32, 0x33, 0x34, 0x35, 0x36, 0x37, 0x38}
-	C1C3C2      = 0
-	C1C2C3      = 1
+)
+
+// CiphertextMode selects the byte order of the raw SM2 ciphertext components.
+type CiphertextMode int
+
+const (
+	C1C3C2 CiphertextMode = iota // GM/T 0003 order, the default
+	C1C2C3                       // legacy order used by some vendors
 )
 
 type PublicKey struct {
@@ -47,36 +57,365 @@ type PrivateKey struct {
 	D *big.Int
 }
 
-type sm2Signature struct {
+// Signature is an SM2 signature (r, s). Its ASN.1 encoding is the
+// SEQUENCE { r INTEGER, s INTEGER } produced by MarshalSignatureASN1.
+//
+// Unlike ECDSA, SM2 signatures have no low-S/high-S ambiguity: verification
+// uses t = r + s, so (r, n-s) does not verify for the same message and there
+// is nothing to normalize. Verification already rejects r and s outside
+// [1, n-1], which is the only non-canonical form.
+type Signature struct {
 	R, S *big.Int
 }
 
 
+var (
+	_ crypto.Signer    = (*PrivateKey)(nil)
+	_ crypto.Decrypter = (*PrivateKey)(nil)
+)
+
 // The SM2's private key contains the public key
 func (priv *PrivateKey) Public() crypto.PublicKey {
 	return &priv.PublicKey
 }
 
+// Equal reports whether pub and other are the same SM2 public key.
+func (pub *PublicKey) Equal(other crypto.PublicKey) bool {
+	o, ok := other.(*PublicKey)
+	if !ok || pub == nil || o == nil || pub.X == nil || o.X == nil {
+		return false
+	}
+	return pub.Curve == o.Curve && pub.X.Cmp(o.X) == 0 && pub.Y.Cmp(o.Y) == 0
+}
+
+// Equal reports whether priv and other are the same SM2 private key. The
+// scalars are compared in constant time.
+func (priv *PrivateKey) Equal(other crypto.PrivateKey) bool {
+	o, ok := other.(*PrivateKey)
+	if !ok || priv == nil || o == nil || priv.D == nil || o.D == nil {
+		return false
+	}
+	return priv.PublicKey.Equal(&o.PublicKey) &&
+		subtle.ConstantTimeCompare(priv.Bytes(), o.Bytes()) == 1
+}
+
+// IsValid reports whether pub is a point on its curve other than the point
+// at infinity. Public keys taken from untrusted input must be checked before
+// they are used for encryption, verification or key agreement.
+func (pub *PublicKey) IsValid() bool {
+	if pub == nil || pub.Curve == nil || pub.X == nil || pub.Y == nil {
+		return false
+	}
+	if pub.X.Sign() == 0 && pub.Y.Sign() == 0 {
+		return false
+	}
+	return pub.Curve.IsOnCurve(pub.X, pub.Y)
+}
+
+// Marshal encodes the public key in the uncompressed form 0x04||X||Y.
+func (pub *PublicKey) Marshal() []byte {
+	out := []byte{4}
+	out = append(out, toBytes(pub.Curve, pub.X)...)
+	return append(out, toBytes(pub.Curve, pub.Y)...)
+}
+
+// MarshalCompressed encodes the public key in the compressed form
+// (0x02 or 0x03 depending on the parity of Y)||X.
+func (pub *PublicKey) MarshalCompressed() []byte {
+	out := []byte{2 + byte(getLastBit(pub.Y))}
+	return append(out, toBytes(pub.Curve, pub.X)...)
+}
+
+// UnmarshalPublicKey parses an SM2 public key in either the uncompressed or
+// the compressed form. The point must lie on the curve.
+func UnmarshalPublicKey(data []byte) (*PublicKey, error) {
+	curve := P256Sm2()
+	params := curve.Params()
+	byteLen := (params.BitSize + 7) >> 3
+	if len(data) == 0 {
+		return nil, errors.New("SM2: invalid public key encoding")
+	}
+	var x, y *big.Int
+	switch {
+	case data[0] == 4 && len(data) == 1+2*byteLen:
+		x = new(big.Int).SetBytes(data[1 : 1+byteLen])
+		y = new(big.Int).SetBytes(data[1+byteLen:])
+	case (data[0] == 2 || data[0] == 3) && len(data) == 1+byteLen:
+		x = new(big.Int).SetBytes(data[1:])
+		if y = decompressY(params, x, uint(data[0]&1)); y == nil {
+			return nil, errors.New("SM2: invalid public key")
+		}
+	default:
+		return nil, errors.New("SM2: invalid public key encoding")
+	}
+	pub := &PublicKey{Curve: curve, X: x, Y: y}
+	if !pub.IsValid() {
+		return nil, errors.New("SM2: invalid public key")
+	}
+	return pub, nil
+}
+
+// jsonCurveName is the curve name used in the JSON form of a public key.
+const jsonCurveName = "sm2p256v1"
+
+type publicKeyJSON struct {
+	Curve string `json:"curve"`
+	X     string `json:"x"`
+	Y     string `json:"y"`
+}
+
+// MarshalJSON encodes pub as {"curve":"sm2p256v1","x":"<hex>","y":"<hex>"}
+// with X and Y as 64 lowercase hex digits.
+func (pub *PublicKey) MarshalJSON() ([]byte, error) {
+	if !pub.IsValid() {
+		return nil, errors.New("SM2: invalid public key")
+	}
+	return json.Marshal(publicKeyJSON{
+		Curve: jsonCurveName,
+		X:     hex.EncodeToString(toBytes(pub.Curve, pub.X)),
+		Y:     hex.EncodeToString(toBytes(pub.Curve, pub.Y)),
+	})
+}
+
+// UnmarshalJSON decodes the form written by MarshalJSON. Only sm2p256v1 is
+// accepted and the point must lie on the curve.
+func (pub *PublicKey) UnmarshalJSON(data []byte) error {
+	var v publicKeyJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if v.Curve != jsonCurveName {
+		return fmt.Errorf("SM2: unsupported curve %q", v.Curve)
+	}
+	curve := P256Sm2()
+	params := curve.Params()
+	byteLen := (params.BitSize + 7) >> 3
+	xb, errX := hex.DecodeString(v.X)
+	yb, errY := hex.DecodeString(v.Y)
+	if errX != nil || errY != nil || len(xb) != byteLen || len(yb) != byteLen {
+		return errors.New("SM2: invalid public key encoding")
+	}
+	x, y := new(big.Int).SetBytes(xb), new(big.Int).SetBytes(yb)
+	key := PublicKey{Curve: curve, X: x, Y: y}
+	if x.Cmp(params.P) >= 0 || y.Cmp(params.P) >= 0 || !key.IsValid() {
+		return errors.New("SM2: invalid public key")
+	}
+	*pub = key
+	return nil
+}
+
+// Zeroize overwrites the words backing D and sets D to zero. The key must not
+// be used afterwards. Security-sensitive code should defer Zeroize once the
+// key is loaded.
+func (priv *PrivateKey) Zeroize() {
+	if priv == nil || priv.D == nil {
+		return
+	}
+	words := priv.D.Bits()
+	for i := range words {
+		words[i] = 0
+	}
+	priv.D.SetInt64(0)
+}
+
+// Bytes returns D as a 32-byte big-endian value, left-padded with zeros.
+// Some GM tools reject scalars that are not exactly 32 bytes.
+func (priv *PrivateKey) Bytes() []byte {
+	return toBytes(priv.Curve, priv.D)
+}
+
+// PrivateKeyFromBytes builds a private key from a 32-byte big-endian scalar,
+// which must be in [1, n-2]. If pub is nil the public key is computed from d;
+// otherwise pub must be the public key of d.
+func PrivateKeyFromBytes(d []byte, pub *PublicKey) (*PrivateKey, error) {
+	c := P256Sm2()
+	params := c.Params()
+	if len(d) != params.BitSize/8 {
+		return nil, errors.New("SM2: private key must be 32 bytes")
+	}
+	k := new(big.Int).SetBytes(d)
+	if k.Sign() == 0 || k.Cmp(new(big.Int).Sub(params.N, one)) >= 0 {
+		return nil, errors.New("SM2: private key out of range")
+	}
+	x, y := c.ScalarBaseMult(d)
+	if pub != nil && (pub.X == nil || pub.Y == nil || pub.X.Cmp(x) != 0 || pub.Y.Cmp(y) != 0) {
+		return nil, errors.New("SM2: private key does not match public key")
+	}
+	priv := new(PrivateKey)
+	priv.PublicKey.Curve = c
+	priv.PublicKey.X, priv.PublicKey.Y = x, y
+	priv.D = k
+	return priv, nil
+}
+
+// decompressY returns the y with the given parity such that (x, y) is on the
+// curve, or nil if there is none.
+func decompressY(params *elliptic.CurveParams, x *big.Int, parity uint) *big.Int {
+	if x.Sign() < 0 || x.Cmp(params.P) >= 0 {
+		return nil
+	}
+	// y² = x³ - 3x + b
+	y := new(big.Int).Mul(x, x)
+	y.Mul(y, x)
+	x3 := new(big.Int).Lsh(x, 1)
+	x3.Add(x3, x)
+	y.Sub(y, x3)
+	y.Add(y, params.B)
+	y.Mod(y, params.P)
+	if y.ModSqrt(y, params.P) == nil {
+		return nil
+	}
+	if getLastBit(y) != parity {
+		y.Sub(params.P, y)
+	}
+	return y
+}
+
 var errZeroParam = errors.New("zero parameter")
 var one = new(big.Int).SetInt64(1)
 var two = new(big.Int).SetInt64(2)
 
+// Sign implements crypto.Signer and returns a DER signature.
+//
+// SM2 hashes ZA || msg with SM3, and ZA depends on the signer's public key,
+// so the caller cannot pre-hash: the digest argument is the message itself
+// and signer is ignored. This is the convention x509 follows when it passes
+// the TBS bytes unhashed for SM2WithSM3. Verify checks such signatures.
+//
 // sign format = 30 + len(z) + 02 + len(r) + r + 02 + len(s) + s, z being what follows its size, ie 02+len(r)+r+02+len(s)+s
 func (priv *PrivateKey) Sign(random io.Reader, msg []byte, signer crypto.SignerOpts) ([]byte, error) {
 	r, s, err := Sm2Sign(priv, msg, nil, random)
 	if err != nil {
 		return nil, err
 	}
-	return asn1.Marshal(sm2Signature{r, s})
+	return MarshalSignatureASN1(r, s)
 }
 
 func (pub *PublicKey) Verify(msg []byte, sign []byte) bool {
-	var sm2Sign sm2Signature
-	_, err := asn1.Unmarshal(sign, &sm2Sign)
+	r, s, err := UnmarshalSignatureASN1(sign)
 	if err != nil {
 		return false
 	}
-	return Sm2Verify(pub, msg, default_uid, sm2Sign.R, sm2Sign.S)
+	return Sm2Verify(pub, msg, default_uid, r, s)
+}
+
+// MarshalSignatureASN1 encodes (r, s) as DER SEQUENCE { r INTEGER, s INTEGER }.
+func MarshalSignatureASN1(r, s *big.Int) ([]byte, error) {
+	if r == nil || s == nil || r.Sign() <= 0 || s.Sign() <= 0 {
+		return nil, errors.New("SM2: invalid signature")
+	}
+	return asn1.Marshal(Signature{r, s})
+}
+
+// UnmarshalSignatureASN1 decodes a DER SEQUENCE { r INTEGER, s INTEGER }.
+func UnmarshalSignatureASN1(der []byte) (r, s *big.Int, err error) {
+	var sig Signature
+	rest, err := asn1.Unmarshal(der, &sig)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(rest) != 0 {
+		return nil, nil, errors.New("SM2: trailing data after signature")
+	}
+	if sig.R.Sign() <= 0 || sig.S.Sign() <= 0 {
+		return nil, nil, errors.New("SM2: signature contained zero or negative values")
+	}
+	return sig.R, sig.S, nil
+}
+
+// VerifyFlexible verifies sig over msg and uid whether sig is a DER
+// SEQUENCE { r, s } or the 64-byte raw r || s concatenation. A well-formed
+// DER encoding takes precedence; an error is returned only when sig is in
+// neither form.
+func VerifyFlexible(pub *PublicKey, msg, uid, sig []byte) (bool, error) {
+	r, s, err := UnmarshalSignatureASN1(sig)
+	if err != nil {
+		if len(sig) != 64 {
+			return false, errors.New("SM2: signature is neither DER nor 64-byte r||s")
+		}
+		r = new(big.Int).SetBytes(sig[:32])
+		s = new(big.Int).SetBytes(sig[32:])
+	}
+	return Sm2Verify(pub, msg, uid, r, s), nil
+}
+
+// Sm3DigestReader is Sm3Digest over everything read from r until EOF. With
+// SignDigest it signs large files without holding them in memory; the result
+// verifies with VerifyReader.
+func (pub *PublicKey) Sm3DigestReader(r io.Reader, uid []byte) ([]byte, error) {
+	if len(uid) == 0 {
+		uid = default_uid
+	}
+	za, err := ZA(pub, uid)
+	if err != nil {
+		return nil, err
+	}
+	h := sm3.New()
+	h.Write(za)
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// VerifyReader verifies the DER signature sig over the content read from r
+// until EOF, streaming it through SM3. An error is returned when sig is not
+// DER or reading r fails; otherwise the result reports whether sig is valid.
+func (pub *PublicKey) VerifyReader(r io.Reader, uid, sig []byte) (bool, error) {
+	rr, ss, err := UnmarshalSignatureASN1(sig)
+	if err != nil {
+		return false, err
+	}
+	digest, err := pub.Sm3DigestReader(r, uid)
+	if err != nil {
+		return false, err
+	}
+	return pub.VerifyDigest(digest, rr, ss), nil
+}
+
+// SignWithUserID signs SM3(ZA || msg), ZA being derived from uid as in GM/T 0003.
+// A nil uid falls back to the default "1234567812345678".
+func (priv *PrivateKey) SignWithUserID(random io.Reader, msg, uid []byte) (r, s *big.Int, err error) {
+	return Sm2Sign(priv, msg, uid, random)
+}
+
+// VerifyWithUserID verifies a signature produced by SignWithUserID with the same uid.
+func (pub *PublicKey) VerifyWithUserID(msg, uid []byte, r, s *big.Int) bool {
+	return Sm2Verify(pub, msg, uid, r, s)
+}
+
+// SignSig is SignWithUserID returning the signature as a Signature.
+func (priv *PrivateKey) SignSig(random io.Reader, msg, uid []byte) (*Signature, error) {
+	r, s, err := Sm2Sign(priv, msg, uid, random)
+	if err != nil {
+		return nil, err
+	}
+	return &Signature{R: r, S: s}, nil
+}
+
+// VerifySig is VerifyWithUserID taking the signature as a Signature.
+func (pub *PublicKey) VerifySig(msg, uid []byte, sig *Signature) bool {
+	if sig == nil || sig.R == nil || sig.S == nil {
+		return false
+	}
+	return Sm2Verify(pub, msg, uid, sig.R, sig.S)
+}
+
+// Marshal returns the DER encoding of sig, identical to
+// MarshalSignatureASN1(sig.R, sig.S).
+func (sig Signature) Marshal() ([]byte, error) {
+	return MarshalSignatureASN1(sig.R, sig.S)
+}
+
+// Unmarshal decodes a DER signature into sig with the checks of
+// UnmarshalSignatureASN1.
+func (sig *Signature) Unmarshal(der []byte) error {
+	r, s, err := UnmarshalSignatureASN1(der)
+	if err != nil {
+		return err
+	}
+	sig.R, sig.S = r, s
+	return nil
 }
 
 func (pub *PublicKey) Sm3Digest(msg, uid []byte) ([]byte, error) {
@@ -94,19 +433,60 @@ func (pub *PublicKey) Sm3Digest(msg, uid []byte) ([]byte, error) {
 		return nil, err
 	}
 
-	return e.Bytes(), nil
+	return toBytes(pub.Curve, e), nil
+}
+// SignDigest signs a precomputed 32-byte digest e directly. No ZA prefix is
+// applied: the caller must already have computed SM3(ZA || msg), e.g. via
+// Sm3Digest, for the signature to verify with VerifyWithUserID.
+func (priv *PrivateKey) SignDigest(random io.Reader, digest []byte) (r, s *big.Int, err error) {
+	if len(digest) != 32 {
+		return nil, nil, errors.New("SM2: digest must be 32 bytes")
+	}
+	return signDigest(priv, new(big.Int).SetBytes(digest), random)
+}
+
+// VerifyDigest verifies a signature over a precomputed digest, the raw-digest
+// counterpart of SignDigest.
+func (pub *PublicKey) VerifyDigest(digest []byte, r, s *big.Int) bool {
+	if len(digest) != 32 {
+		return false
+	}
+	return verifyDigest(pub, new(big.Int).SetBytes(digest), r, s)
 }
+
 func Sm2Sign(priv *PrivateKey, msg, uid []byte, random io.Reader) (r, s *big.Int, err error) {
 	digest, err := priv.PublicKey.Sm3Digest(msg, uid)
 	if err != nil {
 		return nil, nil, err
 	}
-	e := new(big.Int).SetBytes(digest)
+	return signDigest(priv, new(big.Int).SetBytes(digest), random)
+}
+
+// signDigest signs e as in GB/T 32918.2 section 6.1. A fresh k is drawn
+// whenever r = (e + x1) mod n is zero, r + k = n, or s is zero, so degenerate
+// values are never returned; all three are negligible with a uniform k but
+// can be forced with a chosen random source.
+func signDigest(priv *PrivateKey, e *big.Int, random io.Reader) (r, s *big.Int, err error) {
 	c := priv.PublicKey.Curve
 	N := c.Params().N
 	if N.Sign() == 0 {
 		return nil, nil, errZeroParam
 	}
+	// (1+d)^-1 与随机数k无关，在循环外只计算一次；SM2曲线上使用常量时间求逆
+	d1Inv := new(big.Int).Add(priv.D, one)
+	if d1Inv.Cmp(N) >= 0 {
+		d1Inv.Mod(d1Inv, N)
+	}
+	if d1Inv.Sign() == 0 {
+		return nil, nil, errors.New("SM2: invalid private key")
+	}
+	if N.Cmp(P256Sm2().Params().N) == 0 {
+		d1Inv = orderInverse(d1Inv)
+	} else {
+		d1Inv.ModInverse(d1Inv, N)
+	}
+	rD := new(big.Int)
+	t := new(big.Int)
 	var k *big.Int
 	for { // 调整算法细节以实现SM2
 		for {
@@ -119,18 +499,14 @@ func Sm2Sign(priv *PrivateKey, msg, uid []byte, random io.Reader) (r, s *big.Int
 			r.Add(r, e)
 			r.Mod(r, N)
 			if r.Sign() != 0 {
-				if t := new(big.Int).Add(r, k); t.Cmp(N) != 0 {
+				if t.Add(r, k); t.Cmp(N) != 0 {
 					break
 				}
 			}
 
 		}
-		rD := new(big.Int).Mul(priv.D, r)
+		rD.Mul(priv.D, r)
 		s = new(big.Int).Sub(k, rD)
-
-		d1 := new(big.Int).Add(priv.D, one)
-		// (1+priv.D)-1
-		d1Inv := new(big.Int).ModInverse(d1, N)
 		s.Mul(s, d1Inv)
 		s.Mod(s, N)
 		if s.Sign() != 0 {
@@ -140,15 +516,6 @@ func Sm2Sign(priv *PrivateKey, msg, uid []byte, random io.Reader) (r, s *big.Int
 	return
 }
 func Sm2Verify(pub *PublicKey, msg, uid []byte, r, s *big.Int) bool {
-	c := pub.Curve
-	N := c.Params().N
-	one := new(big.Int).SetInt64(1)
-	if r.Cmp(one) < 0 || s.Cmp(one) < 0 {
-		return false
-	}
-	if r.Cmp(N) >= 0 || s.Cmp(N) >= 0 {
-		return false
-	}
 	if len(uid) == 0 {
 		uid = default_uid
 	}
@@ -160,6 +527,96 @@ func Sm2Verify(pub *PublicKey, msg, uid []byte, r, s *big.Int) bool {
 	if err != nil {
 		return false
 	}
+	return verifyDigest(pub, e, r, s)
+}
+
+// RecoverPublicKey returns the public key that produced the signature (r, s)
+// over digest, as created by SignDigest. Bit 0 of recoveryID is the parity of
+// the y coordinate of the signing point and bit 1 is set when its x
+// coordinate was at least n; a signer finds its recoveryID by trying 0 to 3
+// against its own key.
+//
+// Recovery needs the digest e rather than the message and user ID: e is
+// SM3(ZA || M) and ZA itself hashes the public key being recovered.
+func RecoverPublicKey(digest []byte, r, s *big.Int, recoveryID int) (*PublicKey, error) {
+	curve := P256Sm2()
+	params := curve.Params()
+	N := params.N
+	if len(digest) != sm3.Size {
+		return nil, errors.New("SM2: digest must be 32 bytes")
+	}
+	if recoveryID < 0 || recoveryID > 3 {
+		return nil, errors.New("SM2: invalid recovery id")
+	}
+	if r == nil || s == nil || r.Sign() <= 0 || s.Sign() <= 0 || r.Cmp(N) >= 0 || s.Cmp(N) >= 0 {
+		return nil, errors.New("SM2: invalid signature")
+	}
+	t := new(big.Int).Add(r, s)
+	t.Mod(t, N)
+	if t.Sign() == 0 {
+		return nil, errors.New("SM2: invalid signature")
+	}
+
+	// r = (e + x1) mod n，因此 x1 = r - e mod n，必要时再加 n
+	e := new(big.Int).SetBytes(digest)
+	x1 := new(big.Int).Sub(r, e)
+	x1.Mod(x1, N)
+	if recoveryID&2 != 0 {
+		x1.Add(x1, N)
+	}
+	y1 := decompressY(params, x1, uint(recoveryID&1))
+	if y1 == nil {
+		return nil, errors.New("SM2: signature has no point for this recovery id")
+	}
+
+	// (x1, y1) = [s]G + [t]P，故 P = [t⁻¹]((x1, y1) - [s]G)
+	sx, sy := curve.ScalarBaseMult(s.Bytes())
+	sy.Sub(params.P, sy)
+	qx, qy := curve.Add(x1, y1, sx, sy)
+	tInv := new(big.Int).ModInverse(t, N)
+	px, py := curve.ScalarMult(qx, qy, tInv.Bytes())
+	pub := &PublicKey{Curve: curve, X: px, Y: py}
+	if !pub.IsValid() || !pub.VerifyDigest(digest, r, s) {
+		return nil, errors.New("SM2: public key recovery failed")
+	}
+	return pub, nil
+}
+
+// BatchVerify verifies sigs[i] over msgs[i] against pubs[i] with user ID
+// uids[i]. uids may be nil, in which case the default user ID is used for
+// every entry. It reports whether every signature is valid and returns the
+// indices of the ones that are not. If the slices have different lengths it
+// returns false and no indices.
+//
+// An SM2 signature fixes only the x coordinate of the point sG + tP, so the
+// randomized batch equation used for Schnorr-like schemes cannot be applied
+// without recovering y; each entry is verified individually.
+func BatchVerify(pubs []*PublicKey, msgs [][]byte, uids [][]byte, sigs []Signature) (bool, []int) {
+	if len(msgs) != len(pubs) || len(sigs) != len(pubs) || (uids != nil && len(uids) != len(pubs)) {
+		return false, nil
+	}
+	var failed []int
+	for i, pub := range pubs {
+		var uid []byte
+		if uids != nil {
+			uid = uids[i]
+		}
+		if !pub.IsValid() || !Sm2Verify(pub, msgs[i], uid, sigs[i].R, sigs[i].S) {
+			failed = append(failed, i)
+		}
+	}
+	return len(failed) == 0, failed
+}
+
+func verifyDigest(pub *PublicKey, e, r, s *big.Int) bool {
+	if !pub.IsValid() {
+		return false
+	}
+	c := pub.Curve
+	N := c.Params().N
+	if r == nil || s == nil || r.Sign() <= 0 || s.Sign() <= 0 || r.Cmp(N) >= 0 || s.Cmp(N) >= 0 {
+		return false
+	}
 	t := new(big.Int).Add(r, s)
 	t.Mod(t, N)
 	if t.Sign() == 0 {
@@ -191,6 +648,23 @@ func msgHash(za, msg []byte) (*big.Int, error) {
 }
 
 // ZA = H256(ENTLA || IDA || a || b || xG || yG || xA || yA)
+var (
+	zaParamsOnce sync.Once
+	zaParams     []byte
+)
+
+// zaCurveParams returns a || b || Gx || Gy, the curve part of ZA, computed once.
+func zaCurveParams() []byte {
+	zaParamsOnce.Do(func() {
+		out := make([]byte, 0, 128)
+		out = append(out, sm2P256ToBig(&sm2P256.a).Bytes()...)
+		out = append(out, sm2P256.B.Bytes()...)
+		out = append(out, sm2P256.Gx.Bytes()...)
+		zaParams = append(out, sm2P256.Gy.Bytes()...)
+	})
+	return zaParams
+}
+
 func ZA(pub *PublicKey, uid []byte) ([]byte, error) {
 	za := sm3.New()
 	uidLen := len(uid)
@@ -198,84 +672,186 @@ func ZA(pub *PublicKey, uid []byte) ([]byte, error) {
 		return []byte{}, errors.New("SM2: uid too large")
 	}
 	Entla := uint16(8 * uidLen)
-	za.Write([]byte{byte((Entla >> 8) & 0xFF)})
-	za.Write([]byte{byte(Entla & 0xFF)})
+	za.Write([]byte{byte(Entla >> 8), byte(Entla)})
 	if uidLen > 0 {
 		za.Write(uid)
 	}
-	za.Write(sm2P256ToBig(&sm2P256.a).Bytes())
-	za.Write(sm2P256.B.Bytes())
-	za.Write(sm2P256.Gx.Bytes())
-	za.Write(sm2P256.Gy.Bytes())
+	za.Write(zaCurveParams())
 
-	xBuf := pub.X.Bytes()
-	yBuf := pub.Y.Bytes()
-	if n := len(xBuf); n < 32 {
-		xBuf = append(zeroByteSlice()[:32-n], xBuf...)
+	// FillBytes在坐标超过32字节时会panic，畸形公钥须返回错误
+	if pub.X == nil || pub.Y == nil || pub.X.Sign() < 0 || pub.Y.Sign() < 0 || pub.X.BitLen() > 256 || pub.Y.BitLen() > 256 {
+		return []byte{}, errors.New("SM2: invalid public key")
 	}
-	if n := len(yBuf); n < 32 {
-		yBuf = append(zeroByteSlice()[:32-n], yBuf...)
-	}
-	za.Write(xBuf)
-	za.Write(yBuf)
+	var buf [64]byte
+	pub.X.FillBytes(buf[:32])
+	pub.Y.FillBytes(buf[32:])
+	za.Write(buf[:])
 	return za.Sum(nil)[:32], nil
 }
 
-// 32byte
-func zeroByteSlice() []byte {
-	return []byte{
-		0, 0, 0, 0,
-		0, 0, 0, 0,
-		0, 0, 0, 0,
-		0, 0, 0, 0,
-		0, 0, 0, 0,
-		0, 0, 0, 0,
-		0, 0, 0, 0,
-		0, 0, 0, 0,
-	}
-}
-
 /*
 sm2加密，返回asn.1编码格式的密文内容
 
 */
 func Encrypt(random io.Reader, pub *PublicKey, msg []byte) ([]byte, error) {
-	curve := pub.Curve
-	msgLen := len(msg)
-	if msgLen == 0 {
+	if len(msg) == 0 {
 		return nil, nil
 	}
+	x1, y1, c2, c3, err := encrypt(random, pub, msg)
+	if err != nil {
+		return nil, err
+	}
+	return mashalASN1Ciphertext(x1, y1, c2, c3)
+}
+
+/**
+sm2 解密，针对asn1格式进行解密
+ */
+func Decrypt(priv *PrivateKey, ciphertext []byte) ([]byte, error) {
+	x1, y1, c2, c3, err := unmarshalASN1Ciphertext(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	return decrypt(priv, x1, y1, c2, c3)
+}
+
+// Encrypt encrypts msg per GM/T 0003 and returns the raw ciphertext in
+// C1C3C2 order: 0x04 || x1 || y1 || SM3 hash || ciphertext.
+func (pub *PublicKey) Encrypt(random io.Reader, msg []byte) ([]byte, error) {
+	return pub.EncryptWithMode(random, msg, C1C3C2)
+}
+
+// EncryptWithMode is like Encrypt but lays out the ciphertext in the given mode.
+func (pub *PublicKey) EncryptWithMode(random io.Reader, msg []byte, mode CiphertextMode) ([]byte, error) {
+	if len(msg) == 0 {
+		return nil, errors.New("SM2: empty plaintext")
+	}
+	x1, y1, c2, c3, err := encrypt(random, pub, msg)
+	if err != nil {
+		return nil, err
+	}
+	c1 := elliptic.Marshal(pub.Curve, x1, y1)
+	out := make([]byte, 0, len(c1)+len(c3)+len(c2))
+	out = append(out, c1...)
+	switch mode {
+	case C1C3C2:
+		out = append(out, c3...)
+		return append(out, c2...), nil
+	case C1C2C3:
+		out = append(out, c2...)
+		return append(out, c3...), nil
+	default:
+		return nil, errors.New("SM2: unknown ciphertext mode")
+	}
+}
+
+// Decrypt implements crypto.Decrypter. It decrypts a raw ciphertext produced
+// by PublicKey.Encrypt; rand is unused. opts may be nil for the default
+// C1C3C2 layout or a CiphertextMode selecting another one.
+func (priv *PrivateKey) Decrypt(rand io.Reader, ciphertext []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	mode := C1C3C2
+	switch o := opts.(type) {
+	case nil:
+	case CiphertextMode:
+		mode = o
+	default:
+		return nil, fmt.Errorf("SM2: unsupported decrypter options %T", opts)
+	}
+	return priv.DecryptWithMode(ciphertext, mode)
+}
+
+// DecryptWithMode decrypts a raw ciphertext laid out in the given mode.
+func (priv *PrivateKey) DecryptWithMode(ciphertext []byte, mode CiphertextMode) ([]byte, error) {
+	x1, y1, c2, c3, err := unmarshalRawCiphertext(priv.Curve, ciphertext, mode)
+	if err != nil {
+		return nil, err
+	}
+	return decrypt(priv, x1, y1, c2, c3)
+}
+
+// MarshalCiphertextASN1 converts a raw C1C3C2 ciphertext into the GM/T 0009
+// SEQUENCE { x INTEGER, y INTEGER, hash OCTET STRING, cipher OCTET STRING }.
+func MarshalCiphertextASN1(raw []byte) ([]byte, error) {
+	x1, y1, c2, c3, err := unmarshalRawCiphertext(P256Sm2(), raw, C1C3C2)
+	if err != nil {
+		return nil, err
+	}
+	return mashalASN1Ciphertext(x1, y1, c2, c3)
+}
+
+// UnmarshalCiphertextASN1 converts a GM/T 0009 DER ciphertext into the raw
+// C1C3C2 form accepted by PrivateKey.Decrypt.
+func UnmarshalCiphertextASN1(der []byte) ([]byte, error) {
+	x1, y1, c2, c3, err := unmarshalASN1Ciphertext(der)
+	if err != nil {
+		return nil, err
+	}
+	curve := P256Sm2()
+	if x1.Sign() < 0 || y1.Sign() < 0 || x1.BitLen() > 256 || y1.BitLen() > 256 {
+		return nil, errors.New("SM2: invalid asn1 format ciphertext")
+	}
+	out := make([]byte, 0, 65+len(c3)+len(c2))
+	out = append(out, 4)
+	out = append(out, toBytes(curve, x1)...)
+	out = append(out, toBytes(curve, y1)...)
+	out = append(out, c3...)
+	return append(out, c2...), nil
+}
+
+func unmarshalRawCiphertext(curve elliptic.Curve, ciphertext []byte, mode CiphertextMode) (x1, y1 *big.Int, c2, c3 []byte, err error) {
+	byteLen := (curve.Params().BitSize + 7) >> 3
+	c1Len := 1 + 2*byteLen
+	if len(ciphertext) <= c1Len+sm3.Size || ciphertext[0] != 4 {
+		return nil, nil, nil, nil, errors.New("SM2: invalid cipher text")
+	}
+	x1 = new(big.Int).SetBytes(ciphertext[1 : 1+byteLen])
+	y1 = new(big.Int).SetBytes(ciphertext[1+byteLen : c1Len])
+	switch mode {
+	case C1C3C2:
+		c3 = ciphertext[c1Len : c1Len+sm3.Size]
+		c2 = ciphertext[c1Len+sm3.Size:]
+	case C1C2C3:
+		c2 = ciphertext[c1Len : len(ciphertext)-sm3.Size]
+		c3 = ciphertext[len(ciphertext)-sm3.Size:]
+	default:
+		return nil, nil, nil, nil, errors.New("SM2: unknown ciphertext mode")
+	}
+	return x1, y1, c2, c3, nil
+}
+
+// encrypt returns the C1 point, C2 and C3 of the GM/T 0003 encryption scheme.
+func encrypt(random io.Reader, pub *PublicKey, msg []byte) (x1, y1 *big.Int, c2, c3 []byte, err error) {
+	curve := pub.Curve
+	msgLen := len(msg)
 
 	//A3, requirement is to check if h*P is infinite point, h is 1
-	if pub.X.Sign() == 0 && pub.Y.Sign() == 0 {
-		return nil, errors.New("SM2: invalid public key")
+	if !pub.IsValid() {
+		return nil, nil, nil, nil, errors.New("SM2: invalid public key")
 	}
 
-	for {
+	for kdfCount := 1; ; kdfCount++ {
 		k, err := randFieldElement(curve, random)
 		if err != nil {
-			return nil, err
+			return nil, nil, nil, nil, err
 		}
 		//A2, calculate C1 = k * G
-		x1, y1 := curve.ScalarBaseMult(k.Bytes())
+		x1, y1 = curve.ScalarBaseMult(k.Bytes())
 
 		//A4, calculate k * P (point of Public Key)
 		x2, y2 := curve.ScalarMult(pub.X, pub.Y, k.Bytes())
 
 		//A5, calculate t=KDF(x2||y2, klen)
-		var kdfCount int = 0
 		t, success := kdf(msgLen, append(toBytes(curve, x2), toBytes(curve, y2)...))
 		if !success {
-			kdfCount++
 			// 最大尝试100次
-			if kdfCount > 100 {
-				return nil, fmt.Errorf("SM2: A5, failed to calculate valid t, tried %v times", kdfCount)
+			if kdfCount >= 100 {
+				return nil, nil, nil, nil, fmt.Errorf("SM2: A5, failed to calculate valid t, tried %v times", kdfCount)
 			}
 			continue
 		}
 
 		//A6, C2 = M ^ t;
-		c2 := make([]byte, msgLen)
+		c2 = make([]byte, msgLen)
 		for i := 0; i < msgLen; i++ {
 			c2[i] = msg[i] ^ t[i]
 		}
@@ -285,21 +861,23 @@ func Encrypt(random io.Reader, pub *PublicKey, msg []byte) ([]byte, error) {
 		md.Write(toBytes(curve, x2))
 		md.Write(msg)
 		md.Write(toBytes(curve, y2))
-		c3 := md.Sum(nil)
+		c3 = md.Sum(nil)
 
-		return mashalASN1Ciphertext(x1, y1, c2, c3)
+		return x1, y1, c2, c3, nil
 	}
 }
-/**
-sm2 解密，针对asn1格式进行解密
- */
-func Decrypt(priv *PrivateKey, ciphertext []byte) ([]byte, error) {
-	x1, y1, c2, c3, err := unmarshalASN1Ciphertext(ciphertext)
-	if err != nil {
-		return nil, err
-	}
 
+// decrypt recovers the plaintext from the C1 point, C2 and C3.
+func decrypt(priv *PrivateKey, x1, y1 *big.Int, c2, c3 []byte) ([]byte, error) {
 	curve := priv.Curve
+	//B1, C1 must be a point on the curve
+	if !curve.IsOnCurve(x1, y1) {
+		return nil, errors.New("SM2: invalid cipher text")
+	}
+	if len(c3) != sm3.Size {
+		return nil, errors.New("SM2: invalid hash value")
+	}
+
 	x2, y2 := curve.ScalarMult(x1, y1, priv.D.Bytes())
 	msgLen := len(c2)
 	t, success := kdf(msgLen, append(toBytes(curve, x2), toBytes(curve, y2)...))
@@ -317,10 +895,8 @@ func Decrypt(priv *PrivateKey, ciphertext []byte) ([]byte, error) {
 	md.Write(msg)
 	md.Write(toBytes(curve, y2))
 	u := md.Sum(nil)
-	for i := 0; i < sm3.Size; i++ {
-		if c3[i] != u[i] {
-			return nil, errors.New("SM2: invalid hash value")
-		}
+	if subtle.ConstantTimeCompare(c3, u) != 1 {
+		return nil, errors.New("SM2: invalid hash value")
 	}
 	return msg, nil
 }
@@ -399,45 +975,52 @@ func kdf(length int, x ...[]byte) ([]byte, bool) {
 	return c, false
 }
 
+// randFieldElement draws k uniformly from [1, n-1] by rejection sampling:
+// each candidate is read as BitSize/8 big-endian bytes from random, so a
+// reader that returns a chosen k verbatim makes encryption and signing
+// reproducible for known-answer tests.
 func randFieldElement(c elliptic.Curve, random io.Reader) (k *big.Int, err error) {
 	if random == nil {
 		random = rand.Reader //If there is no external trusted random source,please use rand.Reader to instead of it.
 	}
 	params := c.Params()
-	b := make([]byte, params.BitSize/8+8)
-	_, err = io.ReadFull(random, b)
-	if err != nil {
-		return
+	b := make([]byte, params.BitSize/8)
+	for {
+		if _, err = io.ReadFull(random, b); err != nil {
+			return nil, err
+		}
+		k = new(big.Int).SetBytes(b)
+		if k.Sign() != 0 && k.Cmp(params.N) < 0 {
+			return k, nil
+		}
 	}
-	k = new(big.Int).SetBytes(b)
-	n := new(big.Int).Sub(params.N, one)
-	k.Mod(k, n)
-	k.Add(k, one)
-	return
 }
 
+// GenerateKey generates a fresh SM2 key pair. The private scalar is drawn
+// by rejection sampling from [1, n-2]; n-1 is excluded because signing
+// needs (1+d) to be invertible mod n.
 func GenerateKey(random io.Reader) (*PrivateKey, error) {
 	c := P256Sm2()
 	if random == nil {
 		random = rand.Reader //If there is no external trusted random source,please use rand.Reader to instead of it.
 	}
 	params := c.Params()
-	b := make([]byte, params.BitSize/8+8)
-	_, err := io.ReadFull(random, b)
-	if err != nil {
-		return nil, err
+	max := new(big.Int).Sub(params.N, one)
+	b := make([]byte, params.BitSize/8)
+	for {
+		if _, err := io.ReadFull(random, b); err != nil {
+			return nil, err
+		}
+		k := new(big.Int).SetBytes(b)
+		if k.Sign() == 0 || k.Cmp(max) >= 0 {
+			continue
+		}
+		priv := new(PrivateKey)
+		priv.PublicKey.Curve = c
+		priv.D = k
+		priv.PublicKey.X, priv.PublicKey.Y = c.ScalarBaseMult(k.Bytes())
+		return priv, nil
 	}
-
-	k := new(big.Int).SetBytes(b)
-	n := new(big.Int).Sub(params.N, two)
-	k.Mod(k, n)
-	k.Add(k, one)
-	priv := new(PrivateKey)
-	priv.PublicKey.Curve = c
-	priv.D = k
-	priv.PublicKey.X, priv.PublicKey.Y = c.ScalarBaseMult(k.Bytes())
-
-	return priv, nil
 }
 
 type zr struct {
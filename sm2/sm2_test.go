@@ -0,0 +1,966 @@
+package sm2
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"envelope/sm3"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func fromHex(s string) *big.Int {
+	v, ok := new(big.Int).SetString(s, 16)
+	if !ok {
+		panic("bad hex " + s)
+	}
+	return v
+}
+
+func mustDecodeHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// GM/T 0003.5-2012 推荐曲线上的签名示例
+var sm2SignVector = struct {
+	d, x, y  string
+	uid, msg string
+	za, e    string
+	k, r, s  string
+}{
+	d:   "3945208F7B2144B13F36E38AC6D39F95889393692860B51A42FB81EF4DF7C5B8",
+	x:   "09F9DF311E5421A150DD7D161E4BC5C672179FAD1833FC076BB08FF356F35020",
+	y:   "CCEA490CE26775A52DC6EA718CC1AA600AED05FBF35E084A6632F6072DA9AD13",
+	uid: "1234567812345678",
+	msg: "message digest",
+	za:  "B2E14C5C79C6DF5B85F4FE7ED8DB7A262B9DA7E07CCB0EA9F4747B8CCDA8A4F3",
+	e:   "F0B43E94BA45ACCAACE692ED534382EB17E6AB5A19CE7B31F4486FDFC0D28640",
+	k:   "59276E27D506861A16680F3AD9C02DCCEF3CC1FA3CDBE4CE6D54B80DEAC1BC21",
+	r:   "F5A03B0648D2C4630EEAC513E1BB81A15944DA3827D5B74143AC7EACEEE720B3",
+	s:   "B1B6AA29DF212FD8763182BC0D421CA1BB9038FD1F7F42D4840B69C485BBC1AA",
+}
+
+// GM/T 0003.5-2012 推荐曲线上的加密示例，私钥与随机数k同签名示例
+var sm2EncryptVector = struct {
+	msg            string
+	x1, y1, c3, c2 string
+}{
+	msg: "encryption standard",
+	x1:  "04EBFC718E8D1798620432268E77FEB6415E2EDE0E073C0F4F640ECD2E149A73",
+	y1:  "E858F9D81E5430A57B36DAAB8F950A3C64E6EE6A63094D99283AFF767E124DF0",
+	c3:  "59983C18F809E262923C53AEC295D30383B54E39D609D160AFCB1908D0BD8766",
+	c2:  "21886CA989CA9C7D58087307CA93092D651EFA",
+}
+
+func vectorKey() *PrivateKey {
+	v := sm2SignVector
+	return &PrivateKey{
+		PublicKey: PublicKey{Curve: P256Sm2(), X: fromHex(v.x), Y: fromHex(v.y)},
+		D:         fromHex(v.d),
+	}
+}
+
+func newTestKey(t testing.TB) *PrivateKey {
+	priv, err := GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return priv
+}
+
+func TestSignVector(t *testing.T) {
+	v := sm2SignVector
+	priv := vectorKey()
+	pub := &priv.PublicKey
+	x, y := pub.Curve.ScalarBaseMult(priv.D.Bytes())
+	if x.Cmp(pub.X) != 0 || y.Cmp(pub.Y) != 0 {
+		t.Fatal("public key does not match d")
+	}
+
+	za, err := ZA(pub, []byte(v.uid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(za, mustDecodeHex(v.za)) {
+		t.Errorf("ZA = %X, want %s", za, v.za)
+	}
+	e, err := pub.Sm3Digest([]byte(v.msg), []byte(v.uid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(e, mustDecodeHex(v.e)) {
+		t.Errorf("e = %X, want %s", e, v.e)
+	}
+
+	// randFieldElement按原样读取k，故可重现标准中的签名
+	r, s, err := priv.SignWithUserID(bytes.NewReader(mustDecodeHex(v.k)), []byte(v.msg), []byte(v.uid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Cmp(fromHex(v.r)) != 0 || s.Cmp(fromHex(v.s)) != 0 {
+		t.Fatalf("signature = (%X, %X), want (%s, %s)", r, s, v.r, v.s)
+	}
+	if !pub.VerifyWithUserID([]byte(v.msg), []byte(v.uid), fromHex(v.r), fromHex(v.s)) {
+		t.Error("published signature does not verify")
+	}
+}
+
+func TestVerifyWrongUserID(t *testing.T) {
+	v := sm2SignVector
+	pub := &vectorKey().PublicKey
+	r, s := fromHex(v.r), fromHex(v.s)
+	if pub.VerifyWithUserID([]byte(v.msg), []byte("ALICE123@YAHOO.COM"), r, s) {
+		t.Error("signature verified under a different user ID")
+	}
+	if pub.VerifyWithUserID([]byte("message digesT"), []byte(v.uid), r, s) {
+		t.Error("signature verified for a different message")
+	}
+	// uid为nil时使用默认的1234567812345678
+	if !pub.VerifyWithUserID([]byte(v.msg), nil, r, s) {
+		t.Error("nil user ID does not fall back to the default")
+	}
+}
+
+// SM2没有ECDSA那样的低S规范形式：标准示例中的s大于n/2，原样即可验证，
+// 而(r, n-s)不能验证，因此无需归一化。超出[1, n-1]的r、s一律拒绝
+func TestHighSVerifies(t *testing.T) {
+	v := sm2SignVector
+	pub := &vectorKey().PublicKey
+	msg, uid := []byte(v.msg), []byte(v.uid)
+	N := pub.Curve.Params().N
+	r, s := fromHex(v.r), fromHex(v.s)
+	if s.Cmp(new(big.Int).Rsh(N, 1)) <= 0 {
+		t.Fatal("vector s is not in the upper half")
+	}
+	if !pub.VerifyWithUserID(msg, uid, r, s) {
+		t.Error("high-S signature does not verify")
+	}
+	if pub.VerifyWithUserID(msg, uid, r, new(big.Int).Sub(N, s)) {
+		t.Error("(r, n-s) verified")
+	}
+	for _, bad := range []struct{ r, s *big.Int }{
+		{r, new(big.Int)},
+		{r, new(big.Int).Add(s, N)},
+		{new(big.Int).Add(r, N), s},
+		{r, N},
+	} {
+		if pub.VerifyWithUserID(msg, uid, bad.r, bad.s) {
+			t.Errorf("out-of-range signature (%x, %x) verified", bad.r, bad.s)
+		}
+	}
+}
+
+// 用可控的随机源构造退化的k：第一个k使r=0、r+k=n或s=0时，
+// signDigest必须丢弃它并改用第二个k，且恰好读取两个k
+func TestSignDigestRetriesDegenerateK(t *testing.T) {
+	priv := vectorKey()
+	c := priv.Curve
+	N := c.Params().N
+	k1, k2 := big.NewInt(12345), big.NewInt(67890)
+	x1, _ := c.ScalarBaseMult(k1.Bytes())
+	x2, _ := c.ScalarBaseMult(k2.Bytes())
+
+	// e = -x1 时r = 0；e = -x1-k1 时r+k = n
+	eZeroR := new(big.Int).Sub(N, new(big.Int).Mod(x1, N))
+	eRPlusK := new(big.Int).Sub(eZeroR, k1)
+	eRPlusK.Mod(eRPlusK, N)
+
+	// s = 0 当且仅当k = r*d，选d = k1 * r^-1使第一个k得到s = 0
+	eZeroS := big.NewInt(1)
+	r1 := new(big.Int).Add(x1, eZeroS)
+	r1.Mod(r1, N)
+	d := new(big.Int).ModInverse(r1, N)
+	d.Mul(d, k1).Mod(d, N)
+	zeroSKey, err := PrivateKeyFromBytes(d.FillBytes(make([]byte, 32)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		priv *PrivateKey
+		e    *big.Int
+	}{
+		{"r=0", priv, eZeroR},
+		{"r+k=n", priv, eRPlusK},
+		{"s=0", zeroSKey, eZeroS},
+	} {
+		var src bytes.Buffer
+		src.Write(k1.FillBytes(make([]byte, 32)))
+		src.Write(k2.FillBytes(make([]byte, 32)))
+		digest := tc.e.FillBytes(make([]byte, 32))
+		r, s, err := tc.priv.SignDigest(&src, digest)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		want := new(big.Int).Add(x2, tc.e)
+		want.Mod(want, N)
+		if r.Cmp(want) != 0 || src.Len() != 0 {
+			t.Errorf("%s: signature was not made with the second k", tc.name)
+		}
+		if s.Sign() == 0 || !tc.priv.PublicKey.VerifyDigest(digest, r, s) {
+			t.Errorf("%s: signature does not verify", tc.name)
+		}
+	}
+}
+
+// testdata/openssl_ciphertext.der由OpenSSL 3用sm2SignVector的公钥加密得到：
+// openssl pkeyutl -encrypt -inkey key.pem -in msg.txt
+func TestOpenSSLCiphertextASN1(t *testing.T) {
+	der, err := ioutil.ReadFile("testdata/openssl_ciphertext.der")
+	if err != nil {
+		t.Fatal(err)
+	}
+	priv := vectorKey()
+	want := "encryption standard"
+
+	raw, err := UnmarshalCiphertextASN1(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := priv.Decrypt(nil, raw, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg) != want {
+		t.Errorf("Decrypt(raw) = %q, want %q", msg, want)
+	}
+	msg, err = Decrypt(priv, der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg) != want {
+		t.Errorf("Decrypt(der) = %q, want %q", msg, want)
+	}
+
+	again, err := MarshalCiphertextASN1(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again, der) {
+		t.Errorf("MarshalCiphertextASN1 = %X, want %X", again, der)
+	}
+}
+
+// testdata/openssl_signature.der由OpenSSL 3对"message digest"签名得到：
+// openssl dgst -sm3 -sign key.pem -sigopt distid:1234567812345678
+// 其中s的最高位为1，DER编码带有前导0
+func TestOpenSSLSignatureASN1(t *testing.T) {
+	der, err := ioutil.ReadFile("testdata/openssl_signature.der")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := &vectorKey().PublicKey
+	msg, uid := []byte(sm2SignVector.msg), []byte(sm2SignVector.uid)
+
+	r, s, err := UnmarshalSignatureASN1(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pub.VerifyWithUserID(msg, uid, r, s) {
+		t.Error("OpenSSL signature did not verify")
+	}
+	if ok, err := VerifyFlexible(pub, msg, uid, der); !ok || err != nil {
+		t.Errorf("VerifyFlexible = %v, %v", ok, err)
+	}
+	again, err := MarshalSignatureASN1(r, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again, der) {
+		t.Errorf("MarshalSignatureASN1 = %X, want %X", again, der)
+	}
+
+	if _, _, err := UnmarshalSignatureASN1(append(der[:len(der):len(der)], 0)); err == nil {
+		t.Error("trailing data accepted")
+	}
+}
+
+func TestMalformedPublicKeyNoPanic(t *testing.T) {
+	huge := new(big.Int).Lsh(big.NewInt(1), 300)
+	for _, pub := range []*PublicKey{
+		{Curve: P256Sm2(), X: huge, Y: big.NewInt(1)},
+		{Curve: P256Sm2(), X: big.NewInt(1), Y: huge},
+		{Curve: P256Sm2(), X: big.NewInt(-1), Y: big.NewInt(1)},
+		{Curve: P256Sm2()},
+	} {
+		if _, err := ZA(pub, nil); err == nil {
+			t.Error("ZA accepted an out-of-range public key")
+		}
+		if Sm2Verify(pub, []byte("msg"), nil, big.NewInt(1), big.NewInt(1)) {
+			t.Error("Sm2Verify accepted an out-of-range public key")
+		}
+		if _, err := pub.Sm3Digest([]byte("msg"), nil); err == nil {
+			t.Error("Sm3Digest accepted an out-of-range public key")
+		}
+		if _, err := pub.Sm3DigestReader(bytes.NewReader([]byte("msg")), nil); err == nil {
+			t.Error("Sm3DigestReader accepted an out-of-range public key")
+		}
+	}
+}
+
+func TestDecryptVector(t *testing.T) {
+	v := sm2EncryptVector
+	got, err := vectorKey().Decrypt(nil, mustDecodeHex("04"+v.x1+v.y1+v.c3+v.c2), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != v.msg {
+		t.Errorf("Decrypt = %q, want %q", got, v.msg)
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	priv := newTestKey(t)
+	for _, n := range []int{1, 31, 32, 33, 100} {
+		msg := make([]byte, n)
+		if _, err := rand.Read(msg); err != nil {
+			t.Fatal(err)
+		}
+		ct, err := priv.PublicKey.Encrypt(rand.Reader, msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ct) != 65+32+n {
+			t.Errorf("%d-byte message: %d-byte ciphertext", n, len(ct))
+		}
+		if got, err := priv.Decrypt(nil, ct, nil); err != nil || !bytes.Equal(got, msg) {
+			t.Errorf("%d-byte message: Decrypt = %x, %v", n, got, err)
+		}
+		ct[len(ct)-1] ^= 1
+		if _, err := priv.Decrypt(nil, ct, nil); err == nil {
+			t.Errorf("%d-byte message: tampered ciphertext decrypted", n)
+		}
+
+		der, err := Encrypt(rand.Reader, &priv.PublicKey, msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := Decrypt(priv, der); err != nil || !bytes.Equal(got, msg) {
+			t.Errorf("%d-byte message: ASN.1 Decrypt = %x, %v", n, got, err)
+		}
+	}
+}
+
+// 读取器按原样提供标准中的k，密文须与示例逐字节一致
+func TestEncryptVector(t *testing.T) {
+	v := sm2EncryptVector
+	pub := &vectorKey().PublicKey
+	k := mustDecodeHex(sm2SignVector.k)
+	c1c3c2 := mustDecodeHex("04" + v.x1 + v.y1 + v.c3 + v.c2)
+	ct, err := pub.Encrypt(bytes.NewReader(k), []byte(v.msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(ct, c1c3c2) {
+		t.Errorf("Encrypt = %X, want %X", ct, c1c3c2)
+	}
+	ct, err = pub.EncryptWithMode(bytes.NewReader(k), []byte(v.msg), C1C2C3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := mustDecodeHex("04" + v.x1 + v.y1 + v.c2 + v.c3); !bytes.Equal(ct, want) {
+		t.Errorf("EncryptWithMode(C1C2C3) = %X, want %X", ct, want)
+	}
+}
+
+func TestCiphertextModeMismatch(t *testing.T) {
+	priv := newTestKey(t)
+	msg := []byte("hello mode world!!")
+	for _, c := range []struct{ mode, other CiphertextMode }{
+		{C1C3C2, C1C2C3},
+		{C1C2C3, C1C3C2},
+	} {
+		ct, err := priv.PublicKey.EncryptWithMode(rand.Reader, msg, c.mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := priv.DecryptWithMode(ct, c.mode); err != nil || !bytes.Equal(got, msg) {
+			t.Errorf("mode %d: DecryptWithMode = %q, %v", c.mode, got, err)
+		}
+		if _, err := priv.DecryptWithMode(ct, c.other); err == nil {
+			t.Errorf("mode %d ciphertext decrypted as mode %d", c.mode, c.other)
+		}
+	}
+	if _, err := priv.PublicKey.EncryptWithMode(rand.Reader, msg, CiphertextMode(7)); err == nil {
+		t.Error("unknown ciphertext mode accepted")
+	}
+}
+
+func TestGenerateKey(t *testing.T) {
+	c := P256Sm2()
+	nMinus1 := new(big.Int).Sub(c.Params().N, one)
+	for i := 0; i < 10; i++ {
+		priv := newTestKey(t)
+		if !c.IsOnCurve(priv.X, priv.Y) {
+			t.Errorf("public point (%X, %X) is not on the curve", priv.X, priv.Y)
+		}
+		if priv.D.Sign() <= 0 || priv.D.Cmp(nMinus1) >= 0 {
+			t.Errorf("D = %X is out of range", priv.D)
+		}
+	}
+
+	// 0与n-1都不是合法私钥，须丢弃后重新读取
+	want := bytes.Repeat([]byte{1}, 32)
+	var stream []byte
+	stream = append(stream, make([]byte, 32)...)
+	stream = append(stream, toBytes(c, nMinus1)...)
+	stream = append(stream, want...)
+	priv, err := GenerateKey(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if priv.D.Cmp(new(big.Int).SetBytes(want)) != 0 {
+		t.Errorf("D = %X, want %X", priv.D, want)
+	}
+	if _, err := GenerateKey(bytes.NewReader(make([]byte, 32))); err == nil {
+		t.Error("GenerateKey returned a key after reading only a zero scalar")
+	}
+}
+
+// 调用方自行计算SM3(ZA || M)后直接签名摘要，与带用户ID的接口互通
+func TestSignDigest(t *testing.T) {
+	v := sm2SignVector
+	priv := vectorKey()
+	msg, uid := []byte(v.msg), []byte(v.uid)
+	e := mustDecodeHex(v.e)
+	r, s, err := priv.SignDigest(bytes.NewReader(mustDecodeHex(v.k)), e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Cmp(fromHex(v.r)) != 0 || s.Cmp(fromHex(v.s)) != 0 {
+		t.Errorf("SignDigest = (%X, %X), want (%s, %s)", r, s, v.r, v.s)
+	}
+	if !priv.PublicKey.VerifyWithUserID(msg, uid, r, s) {
+		t.Error("digest signature does not verify with VerifyWithUserID")
+	}
+	r, s, err = priv.SignWithUserID(rand.Reader, msg, uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !priv.PublicKey.VerifyDigest(e, r, s) {
+		t.Error("SignWithUserID signature does not verify with VerifyDigest")
+	}
+	// 摘要不含ZA时不能通过
+	if plain := sm3.Sm3Sum(msg); priv.PublicKey.VerifyDigest(plain, r, s) {
+		t.Error("signature verifies against SM3(msg) without ZA")
+	}
+	if _, _, err := priv.SignDigest(rand.Reader, e[:20]); err == nil {
+		t.Error("20-byte digest accepted")
+	}
+}
+
+func TestKeyExchange(t *testing.T) {
+	a, b := newTestKey(t), newTestKey(t)
+	ra, rb := newTestKey(t), newTestKey(t)
+	ida, idb := []byte("alice@example.com"), []byte("bob@example.com")
+	kb, sb, wantSA, err := KeyExchangeB(48, ida, idb, b, &a.PublicKey, rb, &ra.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ka, wantSB, sa, err := KeyExchangeA(48, ida, idb, a, &b.PublicKey, ra, &rb.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ka) != 48 || !bytes.Equal(ka, kb) {
+		t.Fatalf("derived keys differ: A %X, B %X", ka, kb)
+	}
+	if !bytes.Equal(sb, wantSB) {
+		t.Error("A rejects the SB sent by B")
+	}
+	if !bytes.Equal(sa, wantSA) {
+		t.Error("B rejects the SA sent by A")
+	}
+	if bytes.Equal(sa, sb) {
+		t.Error("SA and SB are equal")
+	}
+
+	// 被篡改的SB，或中间人替换B的临时公钥后传来的SB，A都不应接受
+	tampered := append([]byte(nil), sb...)
+	tampered[0] ^= 1
+	if bytes.Equal(tampered, wantSB) {
+		t.Error("A accepts a tampered SB")
+	}
+	mitm := newTestKey(t)
+	km, mitmSB, _, err := KeyExchangeA(48, ida, idb, a, &b.PublicKey, ra, &mitm.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(km, kb) || bytes.Equal(sb, mitmSB) {
+		t.Error("A accepts B's SB after its ephemeral key was substituted")
+	}
+	ke, _, _, err := KeyExchangeA(48, []byte("eve@example.com"), idb, a, &b.PublicKey, ra, &rb.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(ke, kb) {
+		t.Error("the shared key does not depend on A's user ID")
+	}
+
+	offCurve := &PublicKey{Curve: P256Sm2(), X: rb.X, Y: new(big.Int).Add(rb.Y, one)}
+	if _, _, _, err := KeyExchangeA(48, ida, idb, a, &b.PublicKey, ra, offCurve); err == nil {
+		t.Error("off-curve ephemeral public key accepted")
+	}
+}
+
+func TestPublicKeyMarshal(t *testing.T) {
+	v := sm2SignVector
+	pub := &vectorKey().PublicKey
+	if want := mustDecodeHex("04" + v.x + v.y); !bytes.Equal(pub.Marshal(), want) {
+		t.Errorf("Marshal = %X, want %X", pub.Marshal(), want)
+	}
+	// 示例公钥的Y为奇数
+	if want := mustDecodeHex("03" + v.x); !bytes.Equal(pub.MarshalCompressed(), want) {
+		t.Errorf("MarshalCompressed = %X, want %X", pub.MarshalCompressed(), want)
+	}
+
+	for i := 0; i < 20; i++ {
+		priv := newTestKey(t)
+		for _, enc := range [][]byte{priv.PublicKey.Marshal(), priv.PublicKey.MarshalCompressed()} {
+			got, err := UnmarshalPublicKey(enc)
+			if err != nil {
+				t.Fatalf("%X: %v", enc, err)
+			}
+			if !got.Equal(&priv.PublicKey) {
+				t.Errorf("%X: UnmarshalPublicKey = (%X, %X)", enc, got.X, got.Y)
+			}
+		}
+	}
+
+	offCurve := pub.Marshal()
+	offCurve[64] ^= 1
+	if _, err := UnmarshalPublicKey(offCurve); err == nil {
+		t.Error("UnmarshalPublicKey accepted an off-curve point")
+	}
+}
+
+func TestIsValid(t *testing.T) {
+	priv := vectorKey()
+	if !priv.PublicKey.IsValid() {
+		t.Error("valid public key rejected")
+	}
+	offCurve := &PublicKey{Curve: P256Sm2(), X: priv.X, Y: new(big.Int).Add(priv.Y, one)}
+	for name, pub := range map[string]*PublicKey{
+		"off curve": offCurve,
+		"infinity":  {Curve: P256Sm2(), X: new(big.Int), Y: new(big.Int)},
+		"nil":       nil,
+	} {
+		if pub.IsValid() {
+			t.Errorf("%s public key accepted", name)
+		}
+	}
+	if _, err := offCurve.Encrypt(rand.Reader, []byte("secret")); err == nil {
+		t.Error("Encrypt accepted an off-curve public key")
+	}
+}
+
+func TestBatchVerify(t *testing.T) {
+	var pubs []*PublicKey
+	var msgs, uids [][]byte
+	var sigs []Signature
+	for i := 0; i < 8; i++ {
+		priv := newTestKey(t)
+		msg := []byte(fmt.Sprintf("request %d", i))
+		uid := []byte(fmt.Sprintf("client%d", i))
+		r, s, err := priv.SignWithUserID(rand.Reader, msg, uid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pubs = append(pubs, &priv.PublicKey)
+		msgs = append(msgs, msg)
+		uids = append(uids, uid)
+		sigs = append(sigs, Signature{R: r, S: s})
+	}
+	if ok, failed := BatchVerify(pubs, msgs, uids, sigs); !ok || len(failed) != 0 {
+		t.Fatalf("valid batch: ok = %v, failed = %v", ok, failed)
+	}
+	sigs[5].S = new(big.Int).Add(sigs[5].S, one)
+	if ok, failed := BatchVerify(pubs, msgs, uids, sigs); ok || len(failed) != 1 || failed[0] != 5 {
+		t.Errorf("batch with a corrupt signature at 5: ok = %v, failed = %v", ok, failed)
+	}
+}
+
+func TestRecoverPublicKey(t *testing.T) {
+	for i := 0; i < 10; i++ {
+		priv := newTestKey(t)
+		digest, err := priv.PublicKey.Sm3Digest([]byte("compact signature"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, s, err := priv.SignDigest(rand.Reader, digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := 0
+		for id := 0; id < 4; id++ {
+			if pub, err := RecoverPublicKey(digest, r, s, id); err == nil && pub.Equal(&priv.PublicKey) {
+				found++
+			}
+		}
+		if found != 1 {
+			t.Errorf("signer key recovered for %d recovery IDs, want 1", found)
+		}
+	}
+}
+
+func TestSignDeterministic(t *testing.T) {
+	priv := vectorKey()
+	msg := []byte(sm2SignVector.msg)
+	var sigs [][]byte
+	for i := 0; i < 2; i++ {
+		r, s, err := priv.SignDeterministic(msg, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !Sm2Verify(&priv.PublicKey, msg, nil, r, s) {
+			t.Fatal("deterministic signature does not verify")
+		}
+		der, err := MarshalSignatureASN1(r, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sigs = append(sigs, der)
+	}
+	if !bytes.Equal(sigs[0], sigs[1]) {
+		t.Errorf("signatures differ: %X, %X", sigs[0], sigs[1])
+	}
+	r1, _, _ := priv.SignDeterministic(msg, nil)
+	r2, _, err := priv.SignDeterministic([]byte("other message"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r1.Cmp(r2) == 0 {
+		t.Error("a different message gives the same r")
+	}
+}
+
+// RFC 6979 A.2.5：P-256、SHA-256、消息"sample"时首个候选k
+func TestHMACDRBGRFC6979(t *testing.T) {
+	c := elliptic.P256()
+	x := mustDecodeHex("C9AFA9D845BA75166B5C215767B1D6934E50C3DB36E89B127B8A622B120F6721")
+	h := sha256.Sum256([]byte("sample"))
+	h1 := new(big.Int).SetBytes(h[:])
+	h1.Mod(h1, c.Params().N)
+	k := make([]byte, 32)
+	if _, err := newHMACDRBG(sha256.New, x, toBytes(c, h1)).Read(k); err != nil {
+		t.Fatal(err)
+	}
+	if want := mustDecodeHex("A6E3C57DD01ABE90086538398355DD4C3B17AA873382B0F24D6129493D8AAD60"); !bytes.Equal(k, want) {
+		t.Errorf("k = %X, want %X", k, want)
+	}
+}
+
+func TestSignatureStruct(t *testing.T) {
+	priv := newTestKey(t)
+	msg, uid := []byte("struct form"), []byte("alice")
+	sig, err := priv.SignSig(rand.Reader, msg, uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !priv.PublicKey.VerifySig(msg, uid, sig) || !priv.PublicKey.VerifyWithUserID(msg, uid, sig.R, sig.S) {
+		t.Fatal("signature does not verify")
+	}
+	structDER, err := sig.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pairDER, err := MarshalSignatureASN1(sig.R, sig.S)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(structDER, pairDER) {
+		t.Errorf("Signature.Marshal = %X, MarshalSignatureASN1 = %X", structDER, pairDER)
+	}
+	var back Signature
+	if err := back.Unmarshal(pairDER); err != nil {
+		t.Fatal(err)
+	}
+	if back.R.Cmp(sig.R) != 0 || back.S.Cmp(sig.S) != 0 {
+		t.Errorf("Unmarshal = (%X, %X), want (%X, %X)", back.R, back.S, sig.R, sig.S)
+	}
+	if priv.PublicKey.VerifySig(msg, uid, &Signature{}) {
+		t.Error("empty signature verifies")
+	}
+}
+
+func TestPrivateKeyFromBytes(t *testing.T) {
+	c := P256Sm2()
+	n := c.Params().N
+	// 只有2字节的标量，Bytes须左补零到32字节
+	short := &PrivateKey{PublicKey: PublicKey{Curve: c}, D: big.NewInt(0x1234)}
+	b := short.Bytes()
+	if want := append(make([]byte, 30), 0x12, 0x34); !bytes.Equal(b, want) {
+		t.Fatalf("Bytes = %X, want %X", b, want)
+	}
+	priv, err := PrivateKeyFromBytes(b, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if priv.D.Cmp(short.D) != 0 || !c.IsOnCurve(priv.X, priv.Y) {
+		t.Errorf("PrivateKeyFromBytes = %X", priv.D)
+	}
+	if _, err := PrivateKeyFromBytes(b, &priv.PublicKey); err != nil {
+		t.Errorf("matching public key: %v", err)
+	}
+	if _, err := PrivateKeyFromBytes(b, &vectorKey().PublicKey); err == nil {
+		t.Error("mismatched public key accepted")
+	}
+	for name, d := range map[string][]byte{
+		"unpadded": b[30:],
+		"33 bytes": append([]byte{0}, b...),
+		"zero":     make([]byte, 32),
+		"n-1":      toBytes(c, new(big.Int).Sub(n, one)),
+		"n":        toBytes(c, n),
+	} {
+		if _, err := PrivateKeyFromBytes(d, nil); err == nil {
+			t.Errorf("%s scalar accepted", name)
+		}
+	}
+}
+
+func TestVerifyFlexible(t *testing.T) {
+	v := sm2SignVector
+	pub := &vectorKey().PublicKey
+	msg, uid := []byte(v.msg), []byte(v.uid)
+	der, err := MarshalSignatureASN1(fromHex(v.r), fromHex(v.s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := mustDecodeHex(v.r + v.s)
+	for _, sig := range [][]byte{der, raw} {
+		if ok, err := VerifyFlexible(pub, msg, uid, sig); !ok || err != nil {
+			t.Errorf("%d-byte signature: VerifyFlexible = %v, %v", len(sig), ok, err)
+		}
+		if ok, _ := VerifyFlexible(pub, []byte("other message"), uid, sig); ok {
+			t.Errorf("%d-byte signature verifies for another message", len(sig))
+		}
+	}
+
+	// 新签名按r||s编码后同样通过
+	priv := newTestKey(t)
+	r, s, err := priv.SignWithUserID(rand.Reader, msg, uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fresh := append(toBytes(priv.Curve, r), toBytes(priv.Curve, s)...)
+	if ok, err := VerifyFlexible(&priv.PublicKey, msg, uid, fresh); !ok || err != nil {
+		t.Errorf("raw r||s round trip: %v, %v", ok, err)
+	}
+	for _, sig := range [][]byte{raw[:63], append(raw[:64:64], 0)} {
+		if _, err := VerifyFlexible(pub, msg, uid, sig); err == nil {
+			t.Errorf("%d-byte raw signature accepted", len(sig))
+		}
+	}
+}
+
+func TestPublicKeyJSON(t *testing.T) {
+	v := sm2SignVector
+	pub := &vectorKey().PublicKey
+	data, err := json.Marshal(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"curve":"sm2p256v1","x":"` + strings.ToLower(v.x) + `","y":"` + strings.ToLower(v.y) + `"}`
+	if string(data) != want {
+		t.Errorf("MarshalJSON = %s, want %s", data, want)
+	}
+	var cfg struct {
+		Key *PublicKey `json:"key"`
+	}
+	if err := json.Unmarshal([]byte(`{"key":`+string(data)+`}`), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.Key.Equal(pub) {
+		t.Errorf("UnmarshalJSON = (%X, %X)", cfg.Key.X, cfg.Key.Y)
+	}
+
+	offCurve := `{"curve":"sm2p256v1","x":"` + strings.ToLower(v.x) + `","y":"` + strings.Repeat("0", 63) + `1"}`
+	for _, in := range []string{
+		offCurve,
+		`{"curve":"P-256","x":"` + strings.ToLower(v.x) + `","y":"` + strings.ToLower(v.y) + `"}`,
+		`{"curve":"sm2p256v1","x":"zz","y":"00"}`,
+	} {
+		var got PublicKey
+		if err := json.Unmarshal([]byte(in), &got); err == nil {
+			t.Errorf("UnmarshalJSON accepted %s", in)
+		}
+		if got.X != nil {
+			t.Errorf("UnmarshalJSON(%s) modified the key", in)
+		}
+	}
+}
+
+func TestKeyEqual(t *testing.T) {
+	a, b := vectorKey(), newTestKey(t)
+	same := vectorKey()
+	if !a.Equal(same) || !a.PublicKey.Equal(&same.PublicKey) {
+		t.Error("equal keys compare unequal")
+	}
+	if a.Equal(b) || a.PublicKey.Equal(&b.PublicKey) {
+		t.Error("different keys compare equal")
+	}
+	ec, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Equal(ec) || a.PublicKey.Equal(&ec.PublicKey) || a.PublicKey.Equal(a.PublicKey) {
+		t.Error("SM2 key equals a key of another type")
+	}
+}
+
+func TestCryptoSigner(t *testing.T) {
+	var signer crypto.Signer = newTestKey(t)
+	msg := []byte("signed through crypto.Signer")
+	sig, err := signer.Sign(rand.Reader, msg, crypto.Hash(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, ok := signer.Public().(*PublicKey)
+	if !ok {
+		t.Fatalf("Public returned %T", signer.Public())
+	}
+	if !pub.Verify(msg, sig) {
+		t.Error("signature does not verify")
+	}
+	if pub.Verify([]byte("other message"), sig) {
+		t.Error("signature verifies for another message")
+	}
+}
+
+func TestCryptoDecrypter(t *testing.T) {
+	priv := newTestKey(t)
+	var d crypto.Decrypter = priv
+	msg := []byte("decrypted through crypto.Decrypter")
+	ct, err := priv.PublicKey.Encrypt(rand.Reader, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := d.Decrypt(rand.Reader, ct, nil); err != nil || !bytes.Equal(got, msg) {
+		t.Errorf("Decrypt = %q, %v", got, err)
+	}
+	ct, err = priv.PublicKey.EncryptWithMode(rand.Reader, msg, C1C2C3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := d.Decrypt(nil, ct, C1C2C3); err != nil || !bytes.Equal(got, msg) {
+		t.Errorf("Decrypt with C1C2C3 = %q, %v", got, err)
+	}
+	if _, err := d.Decrypt(nil, ct, crypto.SHA256); err == nil {
+		t.Error("unsupported decrypter options accepted")
+	}
+}
+
+func TestVerifyReader(t *testing.T) {
+	priv := newTestKey(t)
+	uid := []byte("alice")
+	data := make([]byte, 1<<20+17)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "signed.bin")
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	r, s, err := priv.SignWithUserID(rand.Reader, data, uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := MarshalSignatureASN1(r, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if ok, err := priv.PublicKey.VerifyReader(f, uid, sig); !ok || err != nil {
+		t.Errorf("VerifyReader = %v, %v", ok, err)
+	}
+	if ok, _ := priv.PublicKey.VerifyReader(bytes.NewReader(data), []byte("bob"), sig); ok {
+		t.Error("signature verifies with another user ID")
+	}
+	data[100] ^= 1
+	if ok, _ := priv.PublicKey.VerifyReader(bytes.NewReader(data), uid, sig); ok {
+		t.Error("signature verifies over modified content")
+	}
+	if _, err := priv.PublicKey.VerifyReader(bytes.NewReader(data), uid, sig[:len(sig)-1]); err == nil {
+		t.Error("truncated DER signature accepted")
+	}
+}
+
+// 优化前(复用big.Int与缓存曲线参数之前)测得的每次调用分配次数
+const (
+	allocsSignBefore   = 91
+	allocsVerifyBefore = 216
+	allocsZABefore     = 23
+)
+
+func TestSignAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("allocation counts are not meaningful under the race detector")
+	}
+	priv := vectorKey()
+	msg := []byte("allocation test")
+	r, s, err := Sm2Sign(priv, msg, nil, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		name   string
+		before int
+		f      func()
+	}{
+		{"Sign", allocsSignBefore, func() { Sm2Sign(priv, msg, nil, rand.Reader) }},
+		{"Verify", allocsVerifyBefore, func() { Sm2Verify(&priv.PublicKey, msg, nil, r, s) }},
+		{"ZA", allocsZABefore, func() { ZA(&priv.PublicKey, default_uid) }},
+	} {
+		got := testing.AllocsPerRun(20, c.f)
+		t.Logf("%s: %d allocs/op before, %.0f after", c.name, c.before, got)
+		if int(got) >= c.before {
+			t.Errorf("%s: %.0f allocs/op, not below the previous %d", c.name, got, c.before)
+		}
+	}
+}
+
+func BenchmarkSign(b *testing.B) {
+	priv := vectorKey()
+	msg := []byte("benchmark message")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		Sm2Sign(priv, msg, nil, rand.Reader)
+	}
+}
+
+func BenchmarkVerify(b *testing.B) {
+	priv := vectorKey()
+	msg := []byte("benchmark message")
+	r, s, _ := Sm2Sign(priv, msg, nil, rand.Reader)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		Sm2Verify(&priv.PublicKey, msg, nil, r, s)
+	}
+}
+
+func BenchmarkZA(b *testing.B) {
+	pub := &vectorKey().PublicKey
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		ZA(pub, default_uid)
+	}
+}
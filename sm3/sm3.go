@@ -1,14 +1,25 @@
+// Package sm3 implements the SM3 hash algorithm of GM/T 0004-2012.
+//
+// The implementation is plain Go with no assembly, cgo or build constraints,
+// so it builds for every GOOS/GOARCH, including js/wasm, and produces the
+// same digests on big- and little-endian machines.
 package sm3
 
 import (
+	"crypto/hmac"
 	"encoding/binary"
+	"errors"
 	"hash"
+	"io"
+	"os"
+	"strconv"
 )
 
 type SM3 struct {
 	digest [8]uint32
 	length uint64
-	m      []byte
+	buf    [BlockSize]byte // 未满一个分组的待处理数据
+	n      int             // buf中的有效字节数
 }
 
 // The size of a SM3 checksum in bytes.
@@ -27,6 +38,44 @@ func New() hash.Hash {
 	return &sm3
 }
 
+// NewHMAC returns a new HMAC-SM3 hash.Hash keyed with key.
+func NewHMAC(key []byte) hash.Hash {
+	return hmac.New(New, key)
+}
+
+// HKDF derives length bytes from secret with HKDF-SM3 (RFC 5869 extract then
+// expand, HMAC-SM3 as the PRF). A nil salt is treated as Size zero bytes.
+// Different info values yield independent keys from the same secret, e.g.
+// separate encryption and MAC keys. length must be between 0 and 255*Size;
+// anything else is an error.
+func HKDF(secret, salt, info []byte, length int) ([]byte, error) {
+	if length < 0 || length > 255*Size {
+		return nil, errors.New("sm3: invalid HKDF length " + strconv.Itoa(length))
+	}
+	if length == 0 {
+		return []byte{}, nil
+	}
+	if salt == nil {
+		salt = make([]byte, Size)
+	}
+	extract := NewHMAC(salt)
+	extract.Write(secret)
+	prk := extract.Sum(nil)
+
+	expand := NewHMAC(prk)
+	out := make([]byte, 0, (length+Size-1)/Size*Size)
+	var t []byte
+	for i := byte(1); len(out) < length; i++ {
+		expand.Reset()
+		expand.Write(t)
+		expand.Write(info)
+		expand.Write([]byte{i})
+		t = expand.Sum(t[:0])
+		out = append(out, t...)
+	}
+	return out[:length], nil
+}
+
 func (sm3 *SM3) Size() int {
 	return Size
 }
@@ -50,24 +99,19 @@ func (sm3 *SM3) leftRotate(x, i uint32) uint32 { return x<<(i%32) | x>>(32-i%32)
 // 填充: 假设消息m的长度为l比特,则首先将比特 ‘1’ 添加到消息的末尾，再添加 k 个 ‘0’，k 满足 l+1+k = 448
 // 再添加一个64位比特串，该比特串是长度 l 的二进制表示。填充后的消息 m‘ 的比特长度为512的倍数
 // 例如：m=01100001 01100010 01100011，l=24，则 k = 423
-func (sm3 *SM3) padding() []byte {
-	msg := sm3.m
+// 填充结果写入调用方提供的tail，避免每次Sum分配内存
+func (sm3 *SM3) padding(tail *[2 * BlockSize]byte) []byte {
+	msg := tail[:copy(tail[:], sm3.buf[:sm3.n])]
 	msg = append(msg, 0x80) // 添加 1 个 ‘1’ 比特的同时添加了 7 个‘0’ 比特，则k需要满足 l + 1 + 7 + k = 448
 	for len(msg)%BlockSize != 56 {
 		msg = append(msg, 0x00)
 	}
 	// append message length
-	msg = append(msg, uint8(sm3.length>>56&0xff))
-	msg = append(msg, uint8(sm3.length>>48&0xff))
-	msg = append(msg, uint8(sm3.length>>40&0xff))
-	msg = append(msg, uint8(sm3.length>>32&0xff))
-	msg = append(msg, uint8(sm3.length>>24&0xff))
-	msg = append(msg, uint8(sm3.length>>16&0xff))
-	msg = append(msg, uint8(sm3.length>>8&0xff))
-	msg = append(msg, uint8(sm3.length>>0&0xff))
-	return msg
+	return binary.BigEndian.AppendUint64(msg, sm3.length)
 }
 
+// Reset restores the initial IV and discards any buffered partial block, so
+// the hash behaves exactly like one returned by New.
 func (sm3 *SM3) Reset() {
 	// Reset digest
 	sm3.digest[0] = 0x7380166f
@@ -80,28 +124,41 @@ func (sm3 *SM3) Reset() {
 	sm3.digest[7] = 0xb0fb0e4e
 
 	sm3.length = 0 // Reset numberic states
-	sm3.m = []byte{}
+	sm3.n = 0
 }
 
 func (sm3 *SM3) Write(m []byte) (int, error) {
 	length := len(m)
 	sm3.length += uint64(len(m) * 8)
-	msg := append(sm3.m, m...)
-	nblock := len(msg) / BlockSize
-	sm3.update(msg)
-	sm3.m = msg[nblock*BlockSize:]
+	if sm3.n > 0 {
+		c := copy(sm3.buf[sm3.n:], m)
+		sm3.n += c
+		m = m[c:]
+		if sm3.n < BlockSize {
+			return length, nil
+		}
+		sm3.update(sm3.buf[:])
+		sm3.n = 0
+	}
+	if full := len(m) / BlockSize * BlockSize; full > 0 {
+		sm3.update(m[:full])
+		m = m[full:]
+	}
+	sm3.n = copy(sm3.buf[:], m)
 	return length, nil
 }
 
+// Sum appends the current digest to in and returns the resulting slice.
+// It does not change the underlying hash state.
 func (sm3 *SM3) Sum(in []byte) []byte {
-	_, _ = sm3.Write(in)
-	msg := sm3.padding()
-	sm3.update(msg)
-	out := make([]byte, Size)
+	// 在副本上填充并压缩，避免修改正在进行的哈希状态
+	d := *sm3
+	var tail [2 * BlockSize]byte
+	d.update(d.padding(&tail))
 	for i := 0; i < 8; i++ {
-		binary.BigEndian.PutUint32(out[i*4:], sm3.digest[i])
+		in = binary.BigEndian.AppendUint32(in, d.digest[i])
 	}
-	return append(in, out...)
+	return in
 }
 
 func (sm3 *SM3) update(m []byte) {
@@ -164,6 +221,7 @@ func (sm3 *SM3) update(m []byte) {
 	sm3.digest[0], sm3.digest[1], sm3.digest[2], sm3.digest[3], sm3.digest[4], sm3.digest[5], sm3.digest[6], sm3.digest[7] = a, b, c, d, e, f, g, h
 }
 
+// Sm3Sum returns the SM3 digest of data.
 func Sm3Sum(data []byte) []byte {
 	var sm3 SM3
 
@@ -171,3 +229,23 @@ func Sm3Sum(data []byte) []byte {
 	_, _ = sm3.Write(data)
 	return sm3.Sum(nil)
 }
+
+// HashReader returns the SM3 digest of everything read from r until EOF.
+func HashReader(r io.Reader) ([]byte, error) {
+	h := New()
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// HashFile returns the SM3 digest of the named file without reading it into
+// memory in one piece.
+func HashFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return HashReader(f)
+}
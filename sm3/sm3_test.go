@@ -0,0 +1,241 @@
+package sm3
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// GM/T 0004-2012 附录A示例，以及空消息
+var sm3Vectors = []struct {
+	in, out string
+}{
+	{"", "1ab21d8355cfa17f8e61194831e81a8f22bec8c728fefb747ed035eb5082aa2b"},
+	{"abc", "66c7f0f462eeedd9d1f2d46bdc10e4e24167c4875cf2f7a2297da02b8f4ba8e0"},
+	{strings.Repeat("abcd", 16), "debe9ff92275b8a138604889c18e5a4d6fdb70e5387e5765293dcba39c0c5732"},
+}
+
+func TestSm3Vectors(t *testing.T) {
+	for _, v := range sm3Vectors {
+		if got := hex.EncodeToString(Sm3Sum([]byte(v.in))); got != v.out {
+			t.Errorf("Sm3Sum(%q) = %s, want %s", v.in, got, v.out)
+		}
+	}
+}
+
+func TestSm3ChunkedWrite(t *testing.T) {
+	msg := make([]byte, 1000)
+	for i := range msg {
+		msg[i] = byte(i * 7)
+	}
+	want := Sm3Sum(msg)
+	for _, step := range []int{1, 63, 64, 65} {
+		h := New()
+		for i := 0; i < len(msg); i += step {
+			end := i + step
+			if end > len(msg) {
+				end = len(msg)
+			}
+			h.Write(msg[i:end])
+		}
+		if got := h.Sum(nil); !bytes.Equal(got, want) {
+			t.Errorf("step %d: %x, want %x", step, got, want)
+		}
+	}
+	for _, v := range sm3Vectors {
+		h := New()
+		for i := 0; i < len(v.in); i++ {
+			h.Write([]byte{v.in[i]})
+		}
+		if got := hex.EncodeToString(h.Sum(nil)); got != v.out {
+			t.Errorf("bytewise %q = %s, want %s", v.in, got, v.out)
+		}
+	}
+}
+
+func TestSm3SumAppends(t *testing.T) {
+	want, _ := hex.DecodeString(sm3Vectors[1].out)
+	h := New()
+	h.Write([]byte("abc"))
+	prefix := []byte("prefix")
+	out := h.Sum(append([]byte(nil), prefix...))
+	if !bytes.Equal(out[:len(prefix)], prefix) || !bytes.Equal(out[len(prefix):], want) {
+		t.Fatalf("Sum(prefix) = %x", out)
+	}
+}
+
+// Reset须恢复初始IV并清空缓冲的不完整分组，之后的结果与新建的哈希一致
+func TestSm3Reset(t *testing.T) {
+	junk := bytes.Repeat([]byte{0x5a}, 200)
+	h := New()
+	for _, n := range []int{1, 63, 64, 65, 130} {
+		for _, v := range sm3Vectors {
+			h.Write(junk[:n])
+			h.Sum(nil)
+			h.Reset()
+			h.Write([]byte(v.in))
+			if got := hex.EncodeToString(h.Sum(nil)); got != v.out {
+				t.Errorf("after %d-byte write and Reset: Sm3(%q) = %s, want %s", n, v.in, got, v.out)
+			}
+			h.Reset()
+		}
+	}
+
+	m := NewHMAC([]byte("key"))
+	want := m.Sum(nil)
+	m.Write(junk[:65])
+	m.Reset()
+	if got := m.Sum(nil); !bytes.Equal(got, want) {
+		t.Errorf("HMAC after Reset = %x, want %x", got, want)
+	}
+}
+
+// Sum只作用于状态的副本：重复调用结果相同，之后仍可继续写入
+func TestSm3SumDoesNotChangeState(t *testing.T) {
+	h := New()
+	h.Write([]byte("abc"))
+	first := h.Sum(nil)
+	h.Sum([]byte("prefix"))
+	if second := h.Sum(nil); !bytes.Equal(first, second) {
+		t.Errorf("second Sum = %x, want %x", second, first)
+	}
+	h.Write([]byte("abc"))
+	if got, want := h.Sum(nil), Sm3Sum([]byte("abcabc")); !bytes.Equal(got, want) {
+		t.Errorf("Sum after further Write = %x, want %x", got, want)
+	}
+
+	m := NewHMAC([]byte("key"))
+	m.Write([]byte("data"))
+	mac := m.Sum(nil)
+	if out := m.Sum([]byte{1}); out[0] != 1 || !bytes.Equal(out[1:], mac) {
+		t.Errorf("HMAC Sum(prefix) = %x, want 01%x", out, mac)
+	}
+}
+
+func TestHMAC(t *testing.T) {
+	m := NewHMAC([]byte("key"))
+	if m.Size() != Size || m.BlockSize() != BlockSize {
+		t.Fatalf("Size = %d, BlockSize = %d", m.Size(), m.BlockSize())
+	}
+	// 期望值由Python的hmac与hashlib的sm3计算；第二组的密钥长于分组，须先做摘要
+	for _, v := range []struct {
+		key, msg []byte
+		want     string
+	}{
+		{[]byte("key"), []byte("The quick brown fox jumps over the lazy dog"), "bd4a34077888162b210645b8ebf74b9af357303789357a27c7fc457244ebd398"},
+		{bytes.Repeat([]byte{0xaa}, 131), []byte("Test Using Larger Than Block-Size Key - Hash Key First"), "b4fd844e13342002f0b2e0690ea7741f1497d993a70494cea601e657bedf67a0"},
+	} {
+		m := NewHMAC(v.key)
+		m.Write(v.msg)
+		tag := m.Sum(nil)
+		if got := hex.EncodeToString(tag); got != v.want {
+			t.Errorf("HMAC-SM3(%d-byte key) = %s, want %s", len(v.key), got, v.want)
+		}
+		m = NewHMAC(v.key)
+		m.Write(append(v.msg[:len(v.msg):len(v.msg)], '.'))
+		if hmac.Equal(m.Sum(nil), tag) {
+			t.Error("hmac.Equal accepted the tag of a modified message")
+		}
+	}
+}
+
+func TestHKDF(t *testing.T) {
+	// 期望值由Python的hmac与hashlib的sm3按RFC 5869计算，参数取自RFC 5869 A.1
+	ikm := bytes.Repeat([]byte{0x0b}, 22)
+	salt := []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
+	info := []byte{0xf0, 0xf1, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8, 0xf9}
+	for _, v := range []struct {
+		secret, salt, info []byte
+		length             int
+		want               string
+	}{
+		{ikm, salt, info, 42, "c69fe91b7aaee2dd5718d72dcaee0cce93f1b8e41f792da51261b6a517e68b36ed2c595572b01dfa359b"},
+		{[]byte("secret"), nil, nil, 100, "aaef10de7f60634280d41808505c599b8b7d85210920533cbdf1db3fcbc528c658fed7fd434732a1c3ff776653ae17d4764ec3ec55c271c4ba4ccbcf2a3b311ec5ffdfc704817293e1791da461b5de15a362d76dd8543d9fafcf3d6738464e27d0c6e0a5"},
+	} {
+		out, err := HKDF(v.secret, v.salt, v.info, v.length)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hex.EncodeToString(out); got != v.want {
+			t.Errorf("HKDF(%q, %d) = %s, want %s", v.secret, v.length, got, v.want)
+		}
+	}
+
+	enc, _ := HKDF(ikm, nil, []byte("enc"), 16)
+	mac, _ := HKDF(ikm, nil, []byte("mac"), 16)
+	if bytes.Equal(enc, mac) {
+		t.Error("different info values derived the same key")
+	}
+	if out, err := HKDF(ikm, nil, nil, 0); err != nil || len(out) != 0 {
+		t.Errorf("length 0: %x, %v", out, err)
+	}
+	if out, err := HKDF(ikm, nil, nil, 255*Size); err != nil || len(out) != 255*Size {
+		t.Errorf("length 255*Size: %d bytes, %v", len(out), err)
+	}
+	for _, n := range []int{255*Size + 1, -1} {
+		if _, err := HKDF(ikm, nil, nil, n); err == nil {
+			t.Errorf("length %d accepted", n)
+		}
+	}
+}
+
+func TestHashFile(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), 1<<16)
+	data = append(data, "tail"...)
+	path := filepath.Join(t.TempDir(), "data.bin")
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	want := Sm3Sum(data)
+	if got, err := HashFile(path); err != nil || !bytes.Equal(got, want) {
+		t.Errorf("HashFile = %x, %v; want %x", got, err, want)
+	}
+	if got, err := HashReader(bytes.NewReader(data)); err != nil || !bytes.Equal(got, want) {
+		t.Errorf("HashReader = %x, %v; want %x", got, err, want)
+	}
+	if _, err := HashFile(filepath.Join(t.TempDir(), "missing")); !os.IsNotExist(err) {
+		t.Errorf("missing file: %v, want a not-exist error", err)
+	}
+}
+
+// 包文档承诺纯Go实现可在任意平台编译，这里对32位、大端与wasm目标交叉编译并vet；
+// 在linux/amd64上还以GOARCH=386实际运行向量测试。耗时较长，设置SM3_CROSS_COMPILE=1时才运行
+func TestCrossCompile(t *testing.T) {
+	if os.Getenv("SM3_CROSS_COMPILE") != "1" {
+		t.Skip("set SM3_CROSS_COMPILE=1 to cross-compile for other targets")
+	}
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go tool not found")
+	}
+	for _, target := range []struct{ goos, goarch string }{
+		{"linux", "386"},
+		{"linux", "arm"},
+		{"linux", "s390x"},
+		{"linux", "mips64"},
+		{"js", "wasm"},
+	} {
+		for _, args := range [][]string{{"build", "."}, {"vet", "."}} {
+			cmd := exec.Command(goBin, args...)
+			cmd.Env = append(os.Environ(), "GOOS="+target.goos, "GOARCH="+target.goarch, "CGO_ENABLED=0")
+			if out, err := cmd.CombinedOutput(); err != nil {
+				t.Errorf("GOOS=%s GOARCH=%s go %s: %v\n%s", target.goos, target.goarch, args[0], err, out)
+			}
+		}
+	}
+
+	if runtime.GOOS == "linux" && runtime.GOARCH == "amd64" {
+		cmd := exec.Command(goBin, "test", "-run", "^TestSm3Vectors$", ".")
+		cmd.Env = append(os.Environ(), "GOARCH=386", "CGO_ENABLED=0")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Errorf("GOARCH=386 go test: %v\n%s", err, out)
+		}
+	}
+}
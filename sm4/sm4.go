@@ -2,10 +2,24 @@ package sm4
 
 import (
 	"bytes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/subtle"
 	"encoding/binary"
+	"envelope/sm3"
 	"errors"
+	"io"
+	"runtime"
+	"strconv"
+	"sync"
 )
 
+// SM4 holds a private copy of the key, its expansion and the IV given to
+// Init. The block and mode methods only read this state (each call starts
+// from its own copy of the IV), so one SM4 may be shared by concurrent
+// goroutines. Zeroize is the exception: it clears the key and must not run
+// concurrently with other calls.
 type SM4 struct {
 	iv  []byte
 	key []byte
@@ -48,10 +62,85 @@ var ck = [32]uint32{
 	0x10171e25, 0x2c333a41, 0x484f565d, 0x646b7279,
 }
 
+// ErrInvalidPadding is returned when decrypted data does not end in valid
+// PKCS#7 padding.
+var ErrInvalidPadding = errors.New("sm4: invalid PKCS#7 padding")
+
+// ErrInvalidCiphertextLength is returned when CBC or ECB ciphertext is empty
+// or not a multiple of the block size.
+var ErrInvalidCiphertextLength = errors.New("sm4: ciphertext is not a multiple of the block size")
+
+// ErrAuthenticationFailed is returned by OpenCBCHMAC when the HMAC-SM3 tag
+// does not match.
+var ErrAuthenticationFailed = errors.New("sm4: message authentication failed")
+
+type KeySizeError int
+
+func (k KeySizeError) Error() string {
+	return "sm4: invalid key size " + strconv.Itoa(int(k))
+}
+
+// NewCipher creates and returns a new cipher.Block. The key must be 16 bytes.
+func NewCipher(key []byte) (cipher.Block, error) {
+	if len(key) != blockSize {
+		return nil, KeySizeError(len(key))
+	}
+	return &SM4{key: append([]byte(nil), key...), rk: GenerateWorkingKey(key)}, nil
+}
+
 func leftRotate(x, i uint32) uint32 { return x<<(i%32) | x>>(32-i%32) }
-func Init(iv, key []byte) *SM4 {
+
+// GenerateKey returns a 16-byte SM4 key read from rand.
+func GenerateKey(rand io.Reader) ([]byte, error) {
+	return randomBlock(rand)
+}
+
+// GenerateIV returns a 16-byte IV read from rand.
+func GenerateIV(rand io.Reader) ([]byte, error) {
+	return randomBlock(rand)
+}
+
+func randomBlock(rand io.Reader) ([]byte, error) {
+	if rand == nil {
+		return nil, errors.New("sm4: missing random source")
+	}
+	b := make([]byte, blockSize)
+	if _, err := io.ReadFull(rand, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Init returns an SM4 cipher for the given IV and key, both of which must be
+// 16 bytes.
+func Init(iv, key []byte) (*SM4, error) {
+	if len(key) != blockSize {
+		return nil, KeySizeError(len(key))
+	}
+	if len(iv) != blockSize {
+		return nil, errors.New("sm4: invalid IV size " + strconv.Itoa(len(iv)))
+	}
 	rk := GenerateWorkingKey(key)
-	return &SM4{iv: iv, key: key, rk: rk}
+	return &SM4{iv: iv, key: append([]byte(nil), key...), rk: rk}, nil
+}
+
+// BlockSize returns the SM4 block size, so that *SM4 satisfies cipher.Block.
+func (sm4 *SM4) BlockSize() int {
+	return blockSize
+}
+
+// Zeroize overwrites the expanded round keys and the cipher's copy of the
+// key. The slice passed to Init or NewCipher is not touched; callers that own
+// it must clear it themselves. The cipher must not be used afterwards.
+// Security-sensitive code should defer Zeroize as soon as the cipher is
+// created.
+func (sm4 *SM4) Zeroize() {
+	for i := range sm4.rk {
+		sm4.rk[i] = 0
+	}
+	for i := range sm4.key {
+		sm4.key[i] = 0
+	}
 }
 
 func (sm4 *SM4) Encrypt(dst, src []byte) {
@@ -92,12 +181,46 @@ func (sm4 *SM4) ProcessBlock(dst, src []byte, encrypt bool) {
 	}
 }
 
+// PaddingMode selects how Sm4CbcWithPadding pads the plaintext to a whole
+// number of blocks.
+type PaddingMode int
+
+const (
+	PKCS7Padding PaddingMode = iota // PKCS#7, the default used by Sm4Cbc
+	ZeroPadding                     // append zero bytes up to the block boundary
+	NoPadding                       // the caller guarantees block alignment
+)
+
+// Sm4Cbc encrypts or decrypts src in CBC mode with PKCS#7 padding, using the
+// IV passed to Init.
 func (sm4 *SM4) Sm4Cbc(src []byte, encrypt bool) (out []byte, err error) {
+	return sm4.Sm4CbcWithPadding(src, encrypt, PKCS7Padding)
+}
 
+// Sm4CbcWithPadding is like Sm4Cbc but lets the caller choose the padding.
+// On decrypt the ciphertext must be a whole number of blocks and, for
+// PKCS7Padding, the padding must be well formed. ZeroPadding strips every
+// trailing zero byte, so it is only suitable for data that cannot end in 0x00.
+func (sm4 *SM4) Sm4CbcWithPadding(src []byte, encrypt bool, mode PaddingMode) (out []byte, err error) {
 	var inData []byte
 	if encrypt {
-		inData = pkcs7Padding(src)
+		switch mode {
+		case PKCS7Padding:
+			inData = pkcs7Padding(src)
+		case ZeroPadding:
+			inData = zeroPadding(src)
+		case NoPadding:
+			if len(src)%blockSize != 0 {
+				return nil, errors.New("sm4: CBC plaintext is not a multiple of the block size")
+			}
+			inData = src
+		default:
+			return nil, errors.New("sm4: unknown padding mode")
+		}
 	} else {
+		if len(src)%blockSize != 0 || (mode == PKCS7Padding && len(src) == 0) {
+			return nil, ErrInvalidCiphertextLength
+		}
 		inData = src
 	}
 	iv := make([]byte, blockSize)
@@ -112,43 +235,245 @@ func (sm4 *SM4) Sm4Cbc(src []byte, encrypt bool) (out []byte, err error) {
 			copy(out[i*16:i*16+16], out_tmp)
 			iv = out_tmp
 		}
+		return out, nil
+	}
+	for i := 0; i < len(inData)/16; i++ {
+		in_tmp := inData[i*16 : i*16+16]
+		out_tmp := make([]byte, 16)
+		sm4.Decrypt(out_tmp, in_tmp)
+		out_tmp = xor(out_tmp, iv)
+		copy(out[i*16:i*16+16], out_tmp)
+		iv = in_tmp
+	}
+	switch mode {
+	case PKCS7Padding:
+		return pkcs7UnPadding(out)
+	case ZeroPadding:
+		return bytes.TrimRight(out, "\x00"), nil
+	case NoPadding:
+		return out, nil
+	default:
+		return nil, errors.New("sm4: unknown padding mode")
+	}
+}
+
+// Sm4Ecb encrypts or decrypts data in ECB mode with PKCS#7 padding.
+//
+// ECB leaks equal plaintext blocks as equal ciphertext blocks; it is only
+// provided for legacy interfaces that require it. Prefer Sm4Cbc or NewGCM.
+func (sm4 *SM4) Sm4Ecb(data []byte, encrypt bool) ([]byte, error) {
+	var in []byte
+	if encrypt {
+		in = pkcs7Padding(data)
 	} else {
-		for i := 0; i < len(inData)/16; i++ {
-			in_tmp := inData[i*16 : i*16+16]
-			out_tmp := make([]byte, 16)
-			sm4.Decrypt(out_tmp, in_tmp)
-			out_tmp = xor(out_tmp, iv)
-			copy(out[i*16:i*16+16], out_tmp)
-			iv = in_tmp
+		if len(data) == 0 || len(data)%blockSize != 0 {
+			return nil, ErrInvalidCiphertextLength
 		}
-		out, _ = pkcs7UnPadding(out)
+		in = data
+	}
+	out := make([]byte, len(in))
+	for i := 0; i < len(in); i += blockSize {
+		sm4.ProcessBlock(out[i:i+blockSize], in[i:i+blockSize], encrypt)
+	}
+	if encrypt {
+		return out, nil
 	}
+	return pkcs7UnPadding(out)
+}
+
+// Sm4CbcSealIV encrypts plaintext in CBC mode with PKCS#7 padding under a
+// fresh random IV and returns IV || ciphertext. The IV passed to Init is not
+// used.
+func (sm4 *SM4) Sm4CbcSealIV(plaintext []byte) ([]byte, error) {
+	iv, err := GenerateIV(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	c := &SM4{iv: iv, key: sm4.key, rk: sm4.rk}
+	ct, err := c.Sm4Cbc(plaintext, true)
+	if err != nil {
+		return nil, err
+	}
+	return append(iv, ct...), nil
+}
+
+// Sm4CbcOpenIV decrypts data produced by Sm4CbcSealIV, taking the IV from its
+// first 16 bytes.
+func (sm4 *SM4) Sm4CbcOpenIV(data []byte) ([]byte, error) {
+	if len(data) < blockSize {
+		return nil, ErrInvalidCiphertextLength
+	}
+	c := &SM4{iv: data[:blockSize], key: sm4.key, rk: sm4.rk}
+	return c.Sm4Cbc(data[blockSize:], false)
+}
 
+// SealCBCHMAC encrypts plaintext with Sm4CbcSealIV and appends HMAC-SM3 under
+// macKey over IV || ciphertext (encrypt-then-MAC). macKey must be independent
+// of the SM4 key.
+func (sm4 *SM4) SealCBCHMAC(macKey, plaintext []byte) ([]byte, error) {
+	if len(macKey) == 0 {
+		return nil, errors.New("sm4: empty MAC key")
+	}
+	out, err := sm4.Sm4CbcSealIV(plaintext)
+	if err != nil {
+		return nil, err
+	}
+	mac := sm3.NewHMAC(macKey)
+	mac.Write(out)
+	return mac.Sum(out), nil
+}
+
+// OpenCBCHMAC checks the tag of data produced by SealCBCHMAC in constant time
+// and only decrypts once it matches.
+func (sm4 *SM4) OpenCBCHMAC(macKey, data []byte) ([]byte, error) {
+	if len(macKey) == 0 {
+		return nil, errors.New("sm4: empty MAC key")
+	}
+	if len(data) < blockSize+sm3.Size {
+		return nil, ErrInvalidCiphertextLength
+	}
+	body, tag := data[:len(data)-sm3.Size], data[len(data)-sm3.Size:]
+	mac := sm3.NewHMAC(macKey)
+	mac.Write(body)
+	if !hmac.Equal(mac.Sum(nil), tag) {
+		return nil, ErrAuthenticationFailed
+	}
+	return sm4.Sm4CbcOpenIV(body)
+}
+
+// Sm4Ctr encrypts or decrypts data in CTR mode, using the IV passed to Init
+// as the initial counter block. No padding is applied.
+func (sm4 *SM4) Sm4Ctr(data []byte) ([]byte, error) {
+	if len(sm4.iv) != blockSize {
+		return nil, errors.New("sm4: CTR mode requires a 16-byte IV")
+	}
+	out := make([]byte, len(data))
+	cipher.NewCTR(sm4, sm4.iv).XORKeyStream(out, data)
+	return out, nil
+}
+
+// Sm4CtrParallel produces the same output as Sm4Ctr but splits the keystream
+// across up to workers goroutines, each starting from its own counter offset.
+// It pays off for payloads of several MB; workers <= 0 uses runtime.NumCPU().
+func (sm4 *SM4) Sm4CtrParallel(data []byte, workers int) ([]byte, error) {
+	if len(sm4.iv) != blockSize {
+		return nil, errors.New("sm4: CTR mode requires a 16-byte IV")
+	}
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	blocks := (len(data) + blockSize - 1) / blockSize
+	if workers > blocks/minCtrChunkBlocks {
+		workers = blocks / minCtrChunkBlocks
+	}
+	if workers <= 1 {
+		return sm4.Sm4Ctr(data)
+	}
+
+	out := make([]byte, len(data))
+	per := (blocks + workers - 1) / workers
+	var wg sync.WaitGroup
+	for start := 0; start < blocks; start += per {
+		lo := start * blockSize
+		hi := lo + per*blockSize
+		if hi > len(data) {
+			hi = len(data)
+		}
+		wg.Add(1)
+		go func(offset uint64, dst, src []byte) {
+			defer wg.Done()
+			cipher.NewCTR(sm4, ctrAdd(sm4.iv, offset)).XORKeyStream(dst, src)
+		}(uint64(start), out[lo:hi], data[lo:hi])
+	}
+	wg.Wait()
+	return out, nil
+}
+
+// 每个goroutine至少处理的分组数，过小的分片不值得并行
+const minCtrChunkBlocks = 4096
+
+// ctrAdd 返回把128位大端计数器iv加上n后的新计数器，与cipher.NewCTR的递增方式一致
+func ctrAdd(iv []byte, n uint64) []byte {
+	ctr := make([]byte, blockSize)
+	copy(ctr, iv)
+	lo := binary.BigEndian.Uint64(ctr[8:])
+	sum := lo + n
+	binary.BigEndian.PutUint64(ctr[8:], sum)
+	if sum < lo {
+		binary.BigEndian.PutUint64(ctr[:8], binary.BigEndian.Uint64(ctr[:8])+1)
+	}
+	return ctr
+}
+
+// Sm4Cfb encrypts or decrypts data in 128-bit CFB mode, using the IV passed
+// to Init. No padding is applied.
+func (sm4 *SM4) Sm4Cfb(data []byte, encrypt bool) ([]byte, error) {
+	if len(sm4.iv) != blockSize {
+		return nil, errors.New("sm4: CFB mode requires a 16-byte IV")
+	}
+	out := make([]byte, len(data))
+	if encrypt {
+		cipher.NewCFBEncrypter(sm4, sm4.iv).XORKeyStream(out, data)
+	} else {
+		cipher.NewCFBDecrypter(sm4, sm4.iv).XORKeyStream(out, data)
+	}
+	return out, nil
+}
+
+// Sm4Ofb encrypts or decrypts data in OFB mode, using the IV passed to Init.
+// No padding is applied.
+func (sm4 *SM4) Sm4Ofb(data []byte) ([]byte, error) {
+	if len(sm4.iv) != blockSize {
+		return nil, errors.New("sm4: OFB mode requires a 16-byte IV")
+	}
+	out := make([]byte, len(data))
+	cipher.NewOFB(sm4, sm4.iv).XORKeyStream(out, data)
 	return out, nil
 }
 
+// NewGCM returns SM4 in Galois Counter Mode with the standard 12-byte nonce.
+// The IV passed to Init is not used; callers supply a nonce to Seal/Open.
+func (sm4 *SM4) NewGCM() (cipher.AEAD, error) {
+	return cipher.NewGCM(sm4)
+}
+
 func pkcs7Padding(src []byte) []byte {
 	padding := blockSize - len(src)%blockSize
-	padtext := bytes.Repeat([]byte{byte(padding)}, padding)
-	return append(src, padtext...)
+	out := make([]byte, len(src), len(src)+padding)
+	copy(out, src)
+	return append(out, bytes.Repeat([]byte{byte(padding)}, padding)...)
+}
+
+func zeroPadding(src []byte) []byte {
+	out := make([]byte, (len(src)+blockSize-1)/blockSize*blockSize)
+	copy(out, src)
+	return out
 }
 
 func pkcs7UnPadding(src []byte) ([]byte, error) {
 	length := len(src)
+	if length == 0 || length%blockSize != 0 {
+		return nil, ErrInvalidPadding
+	}
 	unpadding := int(src[length-1])
 	if unpadding > blockSize || unpadding == 0 {
-		return nil, errors.New("Invalid pkcs7 padding (unpadding > BlockSize || unpadding == 0)")
+		return nil, ErrInvalidPadding
 	}
 
-	pad := src[len(src)-unpadding:]
-	for i := 0; i < unpadding; i++ {
-		if pad[i] != byte(unpadding) {
-			return nil, errors.New("Invalid pkcs7 padding (pad[i] != unpadding)")
-		}
+	// 检查整个末块，避免出错位置带来的时间差异
+	last := src[length-blockSize:]
+	bad := 0
+	for i := 0; i < blockSize; i++ {
+		inPad := subtle.ConstantTimeLessOrEq(blockSize-unpadding, i)
+		bad |= inPad & (1 - subtle.ConstantTimeByteEq(last[i], byte(unpadding)))
+	}
+	if bad != 0 {
+		return nil, ErrInvalidPadding
 	}
 
 	return src[:(length - unpadding)], nil
 }
+
 func xor(in, iv []byte) (out []byte) {
 	if len(in) != len(iv) {
 		return nil
@@ -197,3 +522,84 @@ func l0(b uint32) uint32 {
 func l1(b uint32) uint32 {
 	return b ^ leftRotate(b, 13) ^ leftRotate(b, 23)
 }
+
+// defaultWrapIV is the RFC 3394 default initial value.
+var defaultWrapIV = []byte{0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6}
+
+// WrapKey wraps key under the 16-byte key-encryption key kek using the
+// RFC 3394 key wrap algorithm with SM4 as the block cipher. key must be at
+// least 16 bytes and a multiple of 8 bytes.
+//
+// WrapKey and UnwrapKey are package functions rather than methods on *SM4:
+// the only key they use is kek, and a method would silently ignore the
+// receiver's own key and IV.
+func WrapKey(kek, key []byte) ([]byte, error) {
+	if len(key) < 16 || len(key)%8 != 0 {
+		return nil, errors.New("sm4: wrapped key must be a multiple of 8 bytes and at least 16 bytes")
+	}
+	c, err := NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	return wrapKey(c, key), nil
+}
+
+// wrapKey 是RFC 3394的包装过程，与具体的128位分组密码无关
+func wrapKey(c cipher.Block, key []byte) []byte {
+	n := len(key) / 8
+	out := make([]byte, 8+len(key))
+	copy(out, defaultWrapIV)
+	copy(out[8:], key)
+
+	b := make([]byte, blockSize)
+	a := out[:8]
+	for j := 0; j < 6; j++ {
+		for i := 1; i <= n; i++ {
+			r := out[i*8 : i*8+8]
+			copy(b, a)
+			copy(b[8:], r)
+			c.Encrypt(b, b)
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(a, binary.BigEndian.Uint64(b[:8])^t)
+			copy(r, b[8:])
+		}
+	}
+	return out
+}
+
+// UnwrapKey reverses WrapKey, returning an error if the integrity check
+// fails.
+func UnwrapKey(kek, wrapped []byte) ([]byte, error) {
+	if len(wrapped) < 24 || len(wrapped)%8 != 0 {
+		return nil, errors.New("sm4: invalid wrapped key length")
+	}
+	c, err := NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	return unwrapKey(c, wrapped)
+}
+
+func unwrapKey(c cipher.Block, wrapped []byte) ([]byte, error) {
+	n := len(wrapped)/8 - 1
+	out := make([]byte, len(wrapped))
+	copy(out, wrapped)
+
+	b := make([]byte, blockSize)
+	a := out[:8]
+	for j := 5; j >= 0; j-- {
+		for i := n; i >= 1; i-- {
+			r := out[i*8 : i*8+8]
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(b, binary.BigEndian.Uint64(a)^t)
+			copy(b[8:], r)
+			c.Decrypt(b, b)
+			copy(a, b[:8])
+			copy(r, b[8:])
+		}
+	}
+	if subtle.ConstantTimeCompare(a, defaultWrapIV) != 1 {
+		return nil, errors.New("sm4: key unwrap integrity check failed")
+	}
+	return out[8:], nil
+}
@@ -0,0 +1,663 @@
+package sm4
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func newTestCipher(t testing.TB) *SM4 {
+	c, err := Init([]byte("0123456789abcdef"), []byte("fedcba9876543210"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func mustDecodeHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// GB/T 32907-2016 附录A的示例：密钥与明文均为0123456789abcdeffedcba9876543210
+const (
+	standardKey       = "0123456789abcdeffedcba9876543210"
+	standardCipher    = "681edf34d206965e86b3e94f536e4246"
+	standardCipher1e6 = "595298c7c6fd271f0402f804c33d3f66"
+)
+
+func TestStandardVector(t *testing.T) {
+	key := mustDecodeHex(standardKey)
+	block, err := NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.BlockSize() != blockSize {
+		t.Errorf("BlockSize = %d", block.BlockSize())
+	}
+	out := make([]byte, blockSize)
+	block.Encrypt(out, key)
+	if got := hex.EncodeToString(out); got != standardCipher {
+		t.Fatalf("Encrypt = %s, want %s", got, standardCipher)
+	}
+	block.Decrypt(out, out)
+	if !bytes.Equal(out, key) {
+		t.Errorf("Decrypt = %x, want %x", out, key)
+	}
+
+	if testing.Short() {
+		return
+	}
+	// 同一密钥下对明文连续加密1000000次
+	copy(out, key)
+	for i := 0; i < 1000000; i++ {
+		block.Encrypt(out, out)
+	}
+	if got := hex.EncodeToString(out); got != standardCipher1e6 {
+		t.Errorf("1000000 iterations = %s, want %s", got, standardCipher1e6)
+	}
+}
+
+// RFC 8998 附录A.1的SM4-GCM示例
+func TestGCMVector(t *testing.T) {
+	c, err := Init(make([]byte, blockSize), mustDecodeHex(standardKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := c.NewGCM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := mustDecodeHex("00001234567800000000abcd")
+	aad := mustDecodeHex("feedfacedeadbeeffeedfacedeadbeefabaddad2")
+	msg := mustDecodeHex("aaaaaaaaaaaaaaaabbbbbbbbbbbbbbbbccccccccccccccccdddddddddddddddd" +
+		"eeeeeeeeeeeeeeeeffffffffffffffffeeeeeeeeeeeeeeeeaaaaaaaaaaaaaaaa")
+	want := "17f399f08c67d5ee19d0dc9969c4bb7d5fd46fd3756489069157b282bb200735" +
+		"d82710ca5c22f0ccfa7cbf93d496ac15a56834cbcf98c397b4024a2691233b8d" +
+		"83de3541e4c2b58177e065a9bf7b62ec"
+	sealed := aead.Seal(nil, nonce, msg, aad)
+	if got := hex.EncodeToString(sealed); got != want {
+		t.Fatalf("Seal = %s, want %s", got, want)
+	}
+	if got, err := aead.Open(nil, nonce, sealed, aad); err != nil || !bytes.Equal(got, msg) {
+		t.Fatalf("Open: %v", err)
+	}
+
+	if _, err := aead.Open(nil, nonce, sealed, []byte("other aad")); err == nil {
+		t.Error("Open accepted mismatched additional data")
+	}
+	for name, i := range map[string]int{"ciphertext": 0, "tag": len(sealed) - 1} {
+		tampered := append([]byte(nil), sealed...)
+		tampered[i] ^= 1
+		if _, err := aead.Open(nil, nonce, tampered, aad); err == nil {
+			t.Errorf("Open accepted a flipped %s byte", name)
+		}
+	}
+}
+
+func TestSm4Ecb(t *testing.T) {
+	key := mustDecodeHex(standardKey)
+	c, err := Init(make([]byte, blockSize), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 整块明文多出一个完整的填充块，首块即为标准示例的密文
+	ct, err := c.Sm4Ecb(key, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := standardCipher + "002a8a4efa863ccad024ac0300bb40d2"
+	if got := hex.EncodeToString(ct); got != want {
+		t.Fatalf("Sm4Ecb = %s, want %s", got, want)
+	}
+	pt, err := c.Sm4Ecb(ct, false)
+	if err != nil || !bytes.Equal(pt, key) {
+		t.Fatalf("decrypt = %x, %v", pt, err)
+	}
+	for _, n := range []int{0, 1, 20, len(ct) - 1} {
+		if _, err := c.Sm4Ecb(ct[:n], false); err != ErrInvalidCiphertextLength {
+			t.Errorf("%d-byte ciphertext: %v, want ErrInvalidCiphertextLength", n, err)
+		}
+	}
+}
+
+func TestSm4Ctr(t *testing.T) {
+	key := mustDecodeHex(standardKey)
+	// 计数器低字节为0xff，第二块起进位
+	iv := mustDecodeHex("000102030405060708090a0b0c0d0eff")
+	c, err := Init(iv, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := bytes.Repeat([]byte("abc"), 11)
+	ct, err := c.Sm4Ctr(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "266b62f687ad85d562eb4d0ea9dddf4c6b260730aa8920d8c36ba2bc4ec55a9afb"
+	if got := hex.EncodeToString(ct); got != want {
+		t.Fatalf("Sm4Ctr = %s, want %s", got, want)
+	}
+	if pt, err := c.Sm4Ctr(ct); err != nil || !bytes.Equal(pt, msg) {
+		t.Fatalf("decrypt = %q, %v", pt, err)
+	}
+
+	// 前两块用原计数器，其余部分从前进两块后的计数器开始
+	first, err := c.Sm4Ctr(msg[:2*blockSize])
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := Init(ctrAdd(iv, 2), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := c2.Sm4Ctr(msg[2*blockSize:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := append(first, second...); !bytes.Equal(got, ct) {
+		t.Errorf("two halves = %x, want %x", got, ct)
+	}
+}
+
+func TestInitRejectsBadLengths(t *testing.T) {
+	for _, n := range []int{0, 8, 15, 17, 24, 32} {
+		if _, err := Init(make([]byte, blockSize), make([]byte, n)); err != KeySizeError(n) {
+			t.Errorf("%d-byte key: %v, want KeySizeError(%d)", n, err, n)
+		}
+		if _, err := Init(make([]byte, n), make([]byte, blockSize)); err == nil {
+			t.Errorf("%d-byte IV accepted", n)
+		}
+		if _, err := NewCipher(make([]byte, n)); err != KeySizeError(n) {
+			t.Errorf("NewCipher with a %d-byte key: %v", n, err)
+		}
+	}
+}
+
+// RFC 3394 4.1 用AES-128包装128位密钥；同一算法换用SM4即为WrapKey
+func TestWrapKeyRFC3394(t *testing.T) {
+	kek := mustDecodeHex("000102030405060708090a0b0c0d0e0f")
+	key := mustDecodeHex("00112233445566778899aabbccddeeff")
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapped := wrapKey(block, key)
+	if got, want := hex.EncodeToString(wrapped), "1fa68b0a8112b447aef34bd8fb5a7b829d3e862371d2cfe5"; got != want {
+		t.Fatalf("AES wrap = %s, want %s", got, want)
+	}
+	if got, err := unwrapKey(block, wrapped); err != nil || !bytes.Equal(got, key) {
+		t.Fatalf("AES unwrap = %x, %v", got, err)
+	}
+}
+
+func TestWrapKey(t *testing.T) {
+	kek := mustDecodeHex("000102030405060708090a0b0c0d0e0f")
+	key := mustDecodeHex("00112233445566778899aabbccddeeff")
+	wrapped, err := WrapKey(kek, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 由OpenSSL的sm4-ecb逐块按RFC 3394计算
+	if got, want := hex.EncodeToString(wrapped), "c72e8dbfefe856259fff77de2023b380a9e2d0b8acb9b6f6"; got != want {
+		t.Fatalf("WrapKey = %s, want %s", got, want)
+	}
+	for _, k := range [][]byte{key, bytes.Repeat([]byte{0x5a}, 24), bytes.Repeat([]byte{0x3c}, 32)} {
+		w, err := WrapKey(kek, k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := UnwrapKey(kek, w); err != nil || !bytes.Equal(got, k) {
+			t.Errorf("%d-byte key: UnwrapKey = %x, %v", len(k), got, err)
+		}
+	}
+
+	for i := range wrapped {
+		tampered := append([]byte(nil), wrapped...)
+		tampered[i] ^= 1
+		if _, err := UnwrapKey(kek, tampered); err == nil {
+			t.Errorf("flipped byte %d not detected", i)
+		}
+	}
+	otherKEK := append([]byte(nil), kek...)
+	otherKEK[0] ^= 1
+	if _, err := UnwrapKey(otherKEK, wrapped); err == nil {
+		t.Error("unwrapped under the wrong KEK")
+	}
+	for _, n := range []int{8, 15, 20} {
+		if _, err := WrapKey(kek, make([]byte, n)); err == nil {
+			t.Errorf("WrapKey accepted a %d-byte key", n)
+		}
+	}
+	if _, err := UnwrapKey(kek, wrapped[:16]); err == nil {
+		t.Error("UnwrapKey accepted a 16-byte input")
+	}
+}
+
+func TestSm4CbcPaddingModes(t *testing.T) {
+	c := newTestCipher(t)
+	for _, msg := range [][]byte{[]byte("hello"), bytes.Repeat([]byte("a"), blockSize), bytes.Repeat([]byte("b"), 33)} {
+		for _, mode := range []PaddingMode{PKCS7Padding, ZeroPadding} {
+			ct, err := c.Sm4CbcWithPadding(msg, true, mode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(ct)%blockSize != 0 || len(ct) < len(msg) {
+				t.Errorf("mode %d: %d-byte ciphertext for %d bytes", mode, len(ct), len(msg))
+			}
+			if pt, err := c.Sm4CbcWithPadding(ct, false, mode); err != nil || !bytes.Equal(pt, msg) {
+				t.Errorf("mode %d: %d bytes: decrypt = %q, %v", mode, len(msg), pt, err)
+			}
+		}
+	}
+	if ct, _ := c.Sm4CbcWithPadding(bytes.Repeat([]byte("a"), blockSize), true, ZeroPadding); len(ct) != blockSize {
+		t.Errorf("ZeroPadding added a block to aligned input: %d bytes", len(ct))
+	}
+
+	aligned := bytes.Repeat([]byte("z"), 2*blockSize)
+	ct, err := c.Sm4CbcWithPadding(aligned, true, NoPadding)
+	if err != nil || len(ct) != len(aligned) {
+		t.Fatalf("NoPadding: %d bytes, %v", len(ct), err)
+	}
+	if pt, err := c.Sm4CbcWithPadding(ct, false, NoPadding); err != nil || !bytes.Equal(pt, aligned) {
+		t.Errorf("NoPadding decrypt = %q, %v", pt, err)
+	}
+	if _, err := c.Sm4CbcWithPadding([]byte("short"), true, NoPadding); err == nil {
+		t.Error("NoPadding accepted unaligned plaintext")
+	}
+	// 末字节为'z'的明文不是合法的PKCS#7填充
+	if _, err := c.Sm4Cbc(ct, false); err != ErrInvalidPadding {
+		t.Errorf("malformed padding: %v, want ErrInvalidPadding", err)
+	}
+	if _, err := c.Sm4CbcWithPadding([]byte("x"), true, PaddingMode(99)); err == nil {
+		t.Error("unknown padding mode accepted")
+	}
+}
+
+func TestPKCS7UnPadding(t *testing.T) {
+	x := func(n int) []byte { return bytes.Repeat([]byte("x"), n) }
+	for _, v := range []struct {
+		in   []byte
+		want int
+	}{
+		{append(x(13), 3, 3, 3), 13},
+		{append(x(15), 1), 15},
+		{bytes.Repeat([]byte{16}, 16), 0},
+		{append(x(16), 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2), 30},
+	} {
+		if out, err := pkcs7UnPadding(v.in); err != nil || len(out) != v.want {
+			t.Errorf("%x: %d bytes, %v; want %d bytes", v.in, len(out), err, v.want)
+		}
+	}
+	for name, in := range map[string][]byte{
+		"all-zero tail":      make([]byte, 16),
+		"pad byte 17":        append(x(15), 17),
+		"pad byte 0xff":      append(x(15), 0xff),
+		"inner byte differs": append(x(13), 3, 2, 3),
+		"short padding run":  append(x(13), 2, 3, 3),
+		"empty":              nil,
+		"partial block":      {1},
+	} {
+		if _, err := pkcs7UnPadding(in); err != ErrInvalidPadding {
+			t.Errorf("%s: %v, want ErrInvalidPadding", name, err)
+		}
+	}
+
+	// 经Sm4Cbc解密得到的全零末块同样须报错
+	c := newTestCipher(t)
+	ct, err := c.Sm4CbcWithPadding(make([]byte, blockSize), true, NoPadding)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Sm4Cbc(ct, false); err != ErrInvalidPadding {
+		t.Errorf("all-zero plaintext block: %v, want ErrInvalidPadding", err)
+	}
+}
+
+// 期望值由OpenSSL的sm4-cfb与sm4-ofb计算
+func TestSm4CfbOfb(t *testing.T) {
+	c, err := Init(mustDecodeHex("000102030405060708090a0b0c0d0e0f"), mustDecodeHex(standardKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := []byte("the quick brown fox jumps over the lazy dog!")
+
+	cfb, err := c.Sm4Cfb(msg, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "72f0f9414cd301ce41ad95f08edf974ae4234a3aebd23a4e965779b33cc8ed4874d4b7819c8c5d97600aaa69"
+	if got := hex.EncodeToString(cfb); got != want {
+		t.Errorf("Sm4Cfb = %s, want %s", got, want)
+	}
+	if pt, err := c.Sm4Cfb(cfb, false); err != nil || !bytes.Equal(pt, msg) {
+		t.Errorf("CFB decrypt = %q, %v", pt, err)
+	}
+
+	ofb, err := c.Sm4Ofb(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = "72f0f9414cd301ce41ad95f08edf974a95803a6cddf6370d127f83e2b851c8543322b824ee737e0854816307"
+	if got := hex.EncodeToString(ofb); got != want {
+		t.Errorf("Sm4Ofb = %s, want %s", got, want)
+	}
+	if pt, err := c.Sm4Ofb(ofb); err != nil || !bytes.Equal(pt, msg) {
+		t.Errorf("OFB decrypt = %q, %v", pt, err)
+	}
+}
+
+// countingReader 依次产生0, 1, 2, ...
+type countingReader byte
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(*r)
+		*r++
+	}
+	return len(p), nil
+}
+
+func TestGenerateKeyIV(t *testing.T) {
+	var r countingReader
+	key, err := GenerateKey(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iv, err := GenerateIV(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(key) != blockSize || len(iv) != blockSize {
+		t.Fatalf("key %d bytes, IV %d bytes", len(key), len(iv))
+	}
+	if key[0] != 0 || key[15] != 15 || iv[0] != 16 || iv[15] != 31 {
+		t.Errorf("key %x, IV %x: not read in order from the reader", key, iv)
+	}
+
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		k, err := GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen[string(k)] {
+			t.Fatalf("key %x generated twice", k)
+		}
+		seen[string(k)] = true
+	}
+
+	if _, err := GenerateKey(bytes.NewReader(make([]byte, 8))); err == nil {
+		t.Error("GenerateKey succeeded with an 8-byte reader")
+	}
+	if _, err := GenerateIV(nil); err == nil {
+		t.Error("GenerateIV succeeded without a reader")
+	}
+}
+
+func TestDecryptRejectsPartialBlocks(t *testing.T) {
+	c := newTestCipher(t)
+	for _, n := range []int{0, 1, 17, 31} {
+		if _, err := c.Sm4Cbc(make([]byte, n), false); err != ErrInvalidCiphertextLength {
+			t.Errorf("CBC, %d bytes: %v, want ErrInvalidCiphertextLength", n, err)
+		}
+		if _, err := c.Sm4Ecb(make([]byte, n), false); err != ErrInvalidCiphertextLength {
+			t.Errorf("ECB, %d bytes: %v, want ErrInvalidCiphertextLength", n, err)
+		}
+	}
+	// 不带填充时空密文解密为空明文
+	if out, err := c.Sm4CbcWithPadding(nil, false, NoPadding); err != nil || len(out) != 0 {
+		t.Errorf("NoPadding, empty input: %x, %v", out, err)
+	}
+}
+
+func TestSm4CbcSealIV(t *testing.T) {
+	c := newTestCipher(t)
+	msg := []byte("hello")
+	a, err := c.Sm4CbcSealIV(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := c.Sm4CbcSealIV(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a) != 2*blockSize {
+		t.Errorf("sealed %d bytes, want %d", len(a), 2*blockSize)
+	}
+	if bytes.Equal(a[:blockSize], b[:blockSize]) || bytes.Equal(a[blockSize:], b[blockSize:]) {
+		t.Error("two seals reused the IV")
+	}
+	if bytes.Equal(a[:blockSize], c.iv) {
+		t.Error("Sm4CbcSealIV used the IV passed to Init")
+	}
+	for _, sealed := range [][]byte{a, b} {
+		if pt, err := c.Sm4CbcOpenIV(sealed); err != nil || !bytes.Equal(pt, msg) {
+			t.Errorf("Sm4CbcOpenIV = %q, %v", pt, err)
+		}
+	}
+	for _, n := range []int{0, blockSize - 1, blockSize} {
+		if _, err := c.Sm4CbcOpenIV(a[:n]); err != ErrInvalidCiphertextLength {
+			t.Errorf("%d bytes: %v, want ErrInvalidCiphertextLength", n, err)
+		}
+	}
+}
+
+func TestCBCHMAC(t *testing.T) {
+	c := newTestCipher(t)
+	macKey := bytes.Repeat([]byte{2}, 32)
+	msg := []byte("hello envelope payload")
+	sealed, err := c.SealCBCHMAC(macKey, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := blockSize + 2*blockSize + 32; len(sealed) != want {
+		t.Fatalf("sealed %d bytes, want %d", len(sealed), want)
+	}
+	if pt, err := c.OpenCBCHMAC(macKey, sealed); err != nil || !bytes.Equal(pt, msg) {
+		t.Fatalf("OpenCBCHMAC = %q, %v", pt, err)
+	}
+
+	// 翻转末块密文后若先解密会得到填充错误，认证失败说明解密之前已检查标签
+	for i := range sealed {
+		tampered := append([]byte(nil), sealed...)
+		tampered[i] ^= 1
+		if _, err := c.OpenCBCHMAC(macKey, tampered); err != ErrAuthenticationFailed {
+			t.Fatalf("flipped byte %d: %v, want ErrAuthenticationFailed", i, err)
+		}
+	}
+	if _, err := c.OpenCBCHMAC([]byte("other key"), sealed); err != ErrAuthenticationFailed {
+		t.Errorf("wrong MAC key: %v, want ErrAuthenticationFailed", err)
+	}
+	if _, err := c.OpenCBCHMAC(macKey, sealed[:blockSize+31]); err != ErrInvalidCiphertextLength {
+		t.Errorf("truncated input: %v, want ErrInvalidCiphertextLength", err)
+	}
+	if _, err := c.SealCBCHMAC(nil, msg); err == nil {
+		t.Error("SealCBCHMAC accepted an empty MAC key")
+	}
+}
+
+// 多个goroutine共用一个*SM4时各模式的结果须与串行结果一致，配合-race运行
+func TestConcurrentUse(t *testing.T) {
+	c := newTestCipher(t)
+	msg := bytes.Repeat([]byte("concurrent"), 100)
+	modes := []struct {
+		name string
+		fn   func([]byte) ([]byte, error)
+	}{
+		{"CBC", func(b []byte) ([]byte, error) { return c.Sm4Cbc(b, true) }},
+		{"ECB", func(b []byte) ([]byte, error) { return c.Sm4Ecb(b, true) }},
+		{"CTR", c.Sm4Ctr},
+		{"CTRParallel", func(b []byte) ([]byte, error) { return c.Sm4CtrParallel(b, 4) }},
+		{"CFB", func(b []byte) ([]byte, error) { return c.Sm4Cfb(b, true) }},
+		{"OFB", c.Sm4Ofb},
+		{"Block", func(b []byte) ([]byte, error) {
+			out := make([]byte, blockSize)
+			c.Encrypt(out, b[:blockSize])
+			return out, nil
+		}},
+	}
+	want := make([][]byte, len(modes))
+	for i, m := range modes {
+		var err error
+		if want[i], err = m.fn(msg); err != nil {
+			t.Fatalf("%s: %v", m.name, err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				for j, m := range modes {
+					got, err := m.fn(msg)
+					if err != nil || !bytes.Equal(got, want[j]) {
+						errs <- fmt.Errorf("%s: concurrent result differs (%v)", m.name, err)
+						return
+					}
+				}
+				ct := want[0]
+				if pt, err := c.Sm4Cbc(ct, false); err != nil || !bytes.Equal(pt, msg) {
+					errs <- fmt.Errorf("CBC decrypt: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+// Init与NewCipher复制密钥：Zeroize只清除内部副本，调用方之后修改密钥也不影响已有的cipher
+func TestZeroizeKeepsCallerKey(t *testing.T) {
+	key := []byte("fedcba9876543210")
+	orig := append([]byte(nil), key...)
+	c, err := Init([]byte("0123456789abcdef"), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := newTestCipher(t).Sm4Cbc([]byte("plaintext"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key[0] ^= 0xff
+	if got, _ := c.Sm4Cbc([]byte("plaintext"), true); !bytes.Equal(got, want) {
+		t.Error("changing the caller's key changed the cipher")
+	}
+	key[0] ^= 0xff
+
+	c.Zeroize()
+	for _, w := range c.rk {
+		if w != 0 {
+			t.Fatal("round keys not cleared")
+		}
+	}
+	for _, b := range c.key {
+		if b != 0 {
+			t.Fatal("key copy not cleared")
+		}
+	}
+	if !bytes.Equal(key, orig) {
+		t.Errorf("Zeroize modified the caller's key: %x", key)
+	}
+
+	block, err := NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block.(*SM4).Zeroize()
+	if !bytes.Equal(key, orig) {
+		t.Errorf("Zeroize after NewCipher modified the caller's key: %x", key)
+	}
+}
+
+// counterIV 返回高64位为hi、低64位为2^64-below的计数器
+func counterIV(hi, below uint64) []byte {
+	iv := make([]byte, blockSize)
+	binary.BigEndian.PutUint64(iv[:8], hi)
+	binary.BigEndian.PutUint64(iv[8:], -below)
+	return iv
+}
+
+func TestSm4CtrParallelMatchesCtr(t *testing.T) {
+	key := []byte("fedcba9876543210")
+	ivs := map[string][]byte{
+		"zero": make([]byte, blockSize),
+		// 低64位在各分片起点之前进位，并行时由ctrAdd处理
+		"low carry": counterIV(7, 100),
+		// 128位计数器整体回绕到0
+		"128-bit wrap":  counterIV(^uint64(0), 100),
+		"wrap in chunk": counterIV(^uint64(0), 5000),
+	}
+	sizes := []int{0, 1, 17, 3*minCtrChunkBlocks*blockSize + 5, 1 << 20}
+	for name, iv := range ivs {
+		c, err := Init(iv, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, n := range sizes {
+			data := make([]byte, n)
+			rand.Read(data)
+			want, err := c.Sm4Ctr(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, workers := range []int{0, 2, 3, 7} {
+				got, err := c.Sm4CtrParallel(data, workers)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Errorf("%s: %d bytes, %d workers: output differs from Sm4Ctr", name, n, workers)
+				}
+			}
+		}
+	}
+}
+
+func TestCtrAdd(t *testing.T) {
+	for _, v := range []struct {
+		iv   []byte
+		n    uint64
+		want []byte
+	}{
+		{counterIV(0, 1), 1, counterIV(1, 0)},
+		{counterIV(^uint64(0), 1), 1, make([]byte, blockSize)},
+		{counterIV(3, 10), 4, counterIV(3, 6)},
+	} {
+		if got := ctrAdd(v.iv, v.n); !bytes.Equal(got, v.want) {
+			t.Errorf("ctrAdd(%x, %d) = %x, want %x", v.iv, v.n, got, v.want)
+		}
+	}
+}
+
+func BenchmarkSm4Ctr(b *testing.B) {
+	c := newTestCipher(b)
+	data := make([]byte, 16<<20)
+	b.Run("Serial", func(b *testing.B) {
+		b.SetBytes(int64(len(data)))
+		for i := 0; i < b.N; i++ {
+			c.Sm4Ctr(data)
+		}
+	})
+	b.Run("Parallel", func(b *testing.B) {
+		b.SetBytes(int64(len(data)))
+		for i := 0; i < b.N; i++ {
+			c.Sm4CtrParallel(data, 0)
+		}
+	})
+}
@@ -164,6 +164,9 @@ func ParseSm2PublicKey(der []byte) (*sm2.PublicKey, error) {
 		X:     x,
 		Y:     y,
 	}
+	if !pub.IsValid() {
+		return nil, errors.New("x509: invalid SM2 public key")
+	}
 	return &pub, nil
 }
 
@@ -214,15 +217,59 @@ func ParseSm2PrivateKey(der []byte) (*sm2.PrivateKey, error) {
 func ParsePKCS8UnecryptedPrivateKey(der []byte) (*sm2.PrivateKey, error) {
 	var privKey pkcs8
 
-	if _, err := asn1.Unmarshal(der, &privKey); err != nil {
+	if rest, err := asn1.Unmarshal(der, &privKey); err != nil {
 		return nil, err
+	} else if len(rest) != 0 {
+		return nil, errors.New("x509: trailing data after PKCS#8 private key")
 	}
 	if !reflect.DeepEqual(privKey.Algo.Algorithm, oidSM2) {
 		return nil, errors.New("x509: not sm2 elliptic curve")
 	}
+	// ecPublicKey 同时用于 NIST 曲线，参数中的曲线 OID 必须是 SM2
+	var namedCurve asn1.ObjectIdentifier
+	if _, err := asn1.Unmarshal(privKey.Algo.Parameters.FullBytes, &namedCurve); err != nil {
+		return nil, errors.New("x509: missing SM2 curve parameters")
+	}
+	if !namedCurve.Equal(oidNamedCurveP256SM2) {
+		return nil, errors.New("x509: not sm2 elliptic curve")
+	}
 	return ParseSm2PrivateKey(privKey.PrivateKey)
 }
 
+// MarshalPKCS8PrivateKey returns the unencrypted PKCS#8 DER encoding of an SM2
+// private key, with the SM2 named curve OID in the algorithm parameters. The
+// result can be read back with ParsePKCS8PrivateKey(der, nil) or OpenSSL.
+func MarshalPKCS8PrivateKey(priv *sm2.PrivateKey) ([]byte, error) {
+	if priv == nil || priv.D == nil || priv.Curve != sm2.P256Sm2() {
+		return nil, errors.New("x509: unsupported SM2 private key")
+	}
+	curvePrivateKey := make([]byte, (priv.Curve.Params().N.BitLen()+7)/8)
+	if len(priv.D.Bytes()) > len(curvePrivateKey) {
+		return nil, errors.New("x509: invalid elliptic curve private key value")
+	}
+	priv.D.FillBytes(curvePrivateKey)
+	inner, err := asn1.Marshal(sm2PrivateKey{
+		Version:    1,
+		PrivateKey: curvePrivateKey,
+		PublicKey:  asn1.BitString{Bytes: elliptic.Marshal(priv.Curve, priv.X, priv.Y)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	params, err := asn1.Marshal(oidNamedCurveP256SM2)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(pkcs8{
+		Version: 0,
+		Algo: pkix.AlgorithmIdentifier{
+			Algorithm:  oidSM2,
+			Parameters: asn1.RawValue{FullBytes: params},
+		},
+		PrivateKey: inner,
+	})
+}
+
 func ParsePKCS8EcryptedPrivateKey(der, pwd []byte) (*sm2.PrivateKey, error) {
 	var keyInfo EncryptedPrivateKeyInfo
 
@@ -285,24 +332,9 @@ func ParsePKCS8PrivateKey(der, pwd []byte) (*sm2.PrivateKey, error) {
 	return ParsePKCS8EcryptedPrivateKey(der, pwd)
 }
 
+// MarshalSm2UnecryptedPrivateKey is the older name of MarshalPKCS8PrivateKey.
 func MarshalSm2UnecryptedPrivateKey(key *sm2.PrivateKey) ([]byte, error) {
-	var r pkcs8
-	var priv sm2PrivateKey
-	var algo pkix.AlgorithmIdentifier
-
-	algo.Algorithm = oidSM2
-	algo.Parameters.Class = 0
-	algo.Parameters.Tag = 6
-	algo.Parameters.IsCompound = false
-	algo.Parameters.FullBytes = []byte{6, 8, 42, 129, 28, 207, 85, 1, 130, 45} // asn1.Marshal(asn1.ObjectIdentifier{1, 2, 156, 10197, 1, 301})
-	priv.Version = 1
-	priv.NamedCurveOID = oidNamedCurveP256SM2
-	priv.PublicKey = asn1.BitString{Bytes: elliptic.Marshal(key.Curve, key.X, key.Y)}
-	priv.PrivateKey = key.D.Bytes()
-	r.Version = 0
-	r.Algo = algo
-	r.PrivateKey, _ = asn1.Marshal(priv)
-	return asn1.Marshal(r)
+	return MarshalPKCS8PrivateKey(key)
 }
 
 func MarshalSm2EcryptedPrivateKey(PrivKey *sm2.PrivateKey, pwd []byte) ([]byte, error) {
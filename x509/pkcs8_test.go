@@ -0,0 +1,56 @@
+package x509
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+// testdata下的PKCS#8私钥均由OpenSSL 3生成
+func TestPKCS8OpenSSLRoundTrip(t *testing.T) {
+	der, err := ioutil.ReadFile("testdata/openssl_sm2_pkcs8.der")
+	if err != nil {
+		t.Fatal(err)
+	}
+	priv, err := ParsePKCS8PrivateKey(der, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if priv.D.Text(16) != "9051db1595e535b52c1664b54ed33bf304340ee32669c9e9cf815c6017cf440d" {
+		t.Errorf("D = %x", priv.D)
+	}
+
+	// 与OpenSSL一样，ECPrivateKey内不重复曲线参数，因此编码结果逐字节一致
+	out, err := MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, der) {
+		t.Errorf("MarshalPKCS8PrivateKey = %X, want %X", out, der)
+	}
+	legacy, err := MarshalSm2UnecryptedPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(legacy, out) {
+		t.Error("MarshalSm2UnecryptedPrivateKey differs from MarshalPKCS8PrivateKey")
+	}
+
+	if _, err := ParsePKCS8PrivateKey(append(der, 0), nil); err == nil {
+		t.Error("trailing data accepted")
+	}
+	if _, err := MarshalPKCS8PrivateKey(nil); err == nil {
+		t.Error("nil key accepted")
+	}
+}
+
+// ecPublicKey也用于NIST曲线，P-256私钥不能被当作SM2私钥解析
+func TestPKCS8RejectsP256(t *testing.T) {
+	der, err := ioutil.ReadFile("testdata/openssl_p256_pkcs8.der")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParsePKCS8PrivateKey(der, nil); err == nil {
+		t.Error("P-256 key accepted")
+	}
+}
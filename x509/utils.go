@@ -12,6 +12,7 @@ import (
 	"envelope/sm2"
 	"errors"
 	"math/big"
+	"time"
 )
 
 func ReadPrivateKeyFromPem(privateKeyPem []byte, pwd []byte) (*sm2.PrivateKey, error) {
@@ -66,6 +67,32 @@ func WritePublicKeyToPem(key *sm2.PublicKey) ([]byte, error) {
 	return certPem, nil
 }
 
+// EncodePrivateKeyToPEM encodes an SM2 private key as an unencrypted
+// "PRIVATE KEY" (PKCS#8) PEM block; see WritePrivateKeyToPem.
+func EncodePrivateKeyToPEM(priv *sm2.PrivateKey) ([]byte, error) {
+	return WritePrivateKeyToPem(priv, nil)
+}
+
+// DecodePrivateKeyFromPEM parses an unencrypted PKCS#8 SM2 private key from
+// PEM; see ReadPrivateKeyFromPem.
+func DecodePrivateKeyFromPEM(data []byte) (*sm2.PrivateKey, error) {
+	return ReadPrivateKeyFromPem(data, nil)
+}
+
+// EncodeCertificateToPEM encodes cert as a "CERTIFICATE" PEM block.
+func EncodeCertificateToPEM(cert *Certificate) ([]byte, error) {
+	if cert == nil || len(cert.Raw) == 0 {
+		return nil, errors.New("x509: missing certificate DER")
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), nil
+}
+
+// DecodeCertificateFromPEM parses the first PEM block in data as a
+// certificate; see ReadCertificateFromPem.
+func DecodeCertificateFromPEM(data []byte) (*Certificate, error) {
+	return ReadCertificateFromPem(data)
+}
+
 //DHex是sm2私钥的真正关键数值
 func ReadPrivateKeyFromHex(Dhex string) (*sm2.PrivateKey, error) {
 	c := sm2.P256Sm2()
@@ -167,7 +194,8 @@ func ReadCertificateFromPem(certPem []byte) (*Certificate, error) {
 // The returned slice is the certificate in DER encoding.
 //
 // All keys types that are implemented via crypto.Signer are supported (This
-// includes *rsa.PublicKey and *ecdsa.PublicKey.)
+// includes *rsa.PublicKey, *ecdsa.PublicKey and *sm2.PublicKey.) If
+// SignatureAlgorithm is zero, SM2 signers use SM2WithSM3.
 func CreateCertificate(template, parent *Certificate, publicKey *sm2.PublicKey, signer crypto.Signer) ([]byte, error) {
 	if template.SerialNumber == nil {
 		return nil, errors.New("x509: no SerialNumber given")
@@ -220,8 +248,9 @@ func CreateCertificate(template, parent *Certificate, publicKey *sm2.PublicKey,
 
 	c.Raw = tbsCertContents
 
+	// SM2 签名在 Sign 内部计算 ZA 与摘要，此处按实际选用的算法判断
 	digest := tbsCertContents
-	switch template.SignatureAlgorithm {
+	switch getSignatureAlgorithmFromAI(signatureAlgorithm) {
 	case SM2WithSM3, SM2WithSHA1, SM2WithSHA256:
 		break
 	default:
@@ -276,6 +305,61 @@ func ParseSm2CertifateToX509(asn1data []byte) (*x509.Certificate, error) {
 	return sm2Cert.ToX509Certificate(), nil
 }
 
+// VerifyWithCert verifies the SM2 signature (r, s) over msg with user ID uid
+// against the public key in cert. It returns an error if cert does not hold
+// an SM2 public key; otherwise the bool reports whether the signature is valid.
+// This lives in x509 rather than sm2 because sm2 cannot import this package.
+func VerifyWithCert(cert *Certificate, msg, uid []byte, r, s *big.Int) (bool, error) {
+	if cert == nil {
+		return false, errors.New("x509: missing certificate")
+	}
+	pub, ok := cert.PublicKey.(*sm2.PublicKey)
+	if !ok {
+		return false, errors.New("x509: certificate does not contain an SM2 public key")
+	}
+	return sm2.Sm2Verify(pub, msg, uid, r, s), nil
+}
+
+// KeyMatchesCertificate reports whether cert holds the public key of priv.
+// The public point is recomputed as D*G rather than taken from priv, so a key
+// whose D and PublicKey disagree does not match. It is the x509 counterpart
+// of a PrivateKey.MatchesCertificate method, which sm2 cannot declare.
+func KeyMatchesCertificate(priv *sm2.PrivateKey, cert *Certificate) bool {
+	if priv == nil || priv.D == nil || priv.D.Sign() <= 0 || cert == nil {
+		return false
+	}
+	pub, ok := cert.PublicKey.(*sm2.PublicKey)
+	if !ok || !pub.IsValid() {
+		return false
+	}
+	x, y := pub.Curve.ScalarBaseMult(priv.Bytes())
+	return x.Cmp(pub.X) == 0 && y.Cmp(pub.Y) == 0
+}
+
+// ValidateSM2 checks that c carries an SM2 public key on the SM2 curve, is
+// signed with SM2-with-SM3 and is within its validity period now. It does not
+// verify the signature or the chain; use Verify or CheckSignatureFrom for that.
+func (c *Certificate) ValidateSM2() error {
+	pub, ok := c.PublicKey.(*sm2.PublicKey)
+	if c.PublicKeyAlgorithm != ECDSA || !ok {
+		return errors.New("x509: certificate does not contain an SM2 public key")
+	}
+	if pub.Curve != sm2.P256Sm2() || !pub.IsValid() {
+		return errors.New("x509: certificate public key is not a point on the SM2 curve")
+	}
+	if c.SignatureAlgorithm != SM2WithSM3 {
+		return errors.New("x509: certificate is not signed with SM2-SM3 but " + c.SignatureAlgorithm.String())
+	}
+	now := time.Now()
+	if now.Before(c.NotBefore) {
+		return errors.New("x509: certificate is not yet valid")
+	}
+	if now.After(c.NotAfter) {
+		return errors.New("x509: certificate has expired")
+	}
+	return nil
+}
+
 // 32byte
 func zeroByteSlice() []byte {
 	return []byte{
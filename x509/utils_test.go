@@ -0,0 +1,219 @@
+package x509
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"envelope/sm2"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrivateKeyPEMRoundTrip(t *testing.T) {
+	priv, err := sm2.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := EncodePrivateKeyToPEM(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	written, err := WritePrivateKeyToPem(priv, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, written) {
+		t.Error("EncodePrivateKeyToPEM differs from WritePrivateKeyToPem")
+	}
+	if block, _ := pem.Decode(data); block == nil || block.Type != "PRIVATE KEY" {
+		t.Fatalf("unexpected PEM output %q", data)
+	}
+	k, err := DecodePrivateKeyFromPEM(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !k.Equal(priv) {
+		t.Error("private key changed in round trip")
+	}
+
+	// OpenSSL写出的PKCS#8私钥
+	der, err := ioutil.ReadFile("testdata/openssl_sm2_pkcs8.der")
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err = DecodePrivateKeyFromPEM(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err = EncodePrivateKeyToPEM(k); err != nil {
+		t.Fatal(err)
+	}
+	if block, _ := pem.Decode(data); !bytes.Equal(block.Bytes, der) {
+		t.Error("OpenSSL key did not round trip")
+	}
+
+	if _, err := DecodePrivateKeyFromPEM([]byte("not pem")); err == nil {
+		t.Error("non-PEM input accepted")
+	}
+}
+
+func TestCertificatePEMRoundTrip(t *testing.T) {
+	priv, err := sm2.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "pem round trip"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := CreateCertificate(tmpl, tmpl, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := EncodeCertificateToPEM(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, decode := range []func([]byte) (*Certificate, error){DecodeCertificateFromPEM, ReadCertificateFromPem} {
+		c, err := decode(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !c.Equal(cert) {
+			t.Error("certificate changed in round trip")
+		}
+	}
+	if _, err := EncodeCertificateToPEM(&Certificate{}); err == nil {
+		t.Error("certificate without DER accepted")
+	}
+}
+
+// selfSignedSM2 返回priv自签名、有效期为[notBefore, notAfter]的证书
+func selfSignedSM2(t *testing.T, priv *sm2.PrivateKey, notBefore, notAfter time.Time) *Certificate {
+	tmpl := &Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sm2 self signed"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	der, err := CreateCertificate(tmpl, tmpl, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// testdataCert 读取testdata下由OpenSSL生成的PEM证书
+func testdataCert(t *testing.T, name string) *Certificate {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := ReadCertificateFromPem(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestVerifyWithCert(t *testing.T) {
+	priv, err := sm2.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _ := sm2.GenerateKey(rand.Reader)
+	cert := selfSignedSM2(t, priv, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	msg := []byte("verify with cert")
+	r, s, err := sm2.Sm2Sign(priv, msg, nil, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := VerifyWithCert(cert, msg, nil, r, s); !ok || err != nil {
+		t.Errorf("matching certificate: %v, %v", ok, err)
+	}
+	if ok, err := VerifyWithCert(cert, []byte("other message"), nil, r, s); ok || err != nil {
+		t.Errorf("wrong message: %v, %v", ok, err)
+	}
+	otherCert := selfSignedSM2(t, other, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	if ok, err := VerifyWithCert(otherCert, msg, nil, r, s); ok || err != nil {
+		t.Errorf("mismatched certificate: %v, %v", ok, err)
+	}
+	if _, err := VerifyWithCert(testdataCert(t, "ecdsa_p256.pem"), msg, nil, r, s); err == nil {
+		t.Error("ECDSA certificate accepted")
+	}
+	if _, err := VerifyWithCert(nil, msg, nil, r, s); err == nil {
+		t.Error("nil certificate accepted")
+	}
+}
+
+func TestKeyMatchesCertificate(t *testing.T) {
+	priv, err := sm2.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _ := sm2.GenerateKey(rand.Reader)
+	cert := selfSignedSM2(t, priv, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	if !KeyMatchesCertificate(priv, cert) {
+		t.Error("matching key rejected")
+	}
+	if KeyMatchesCertificate(other, cert) {
+		t.Error("mismatched key accepted")
+	}
+	// 公钥与证书一致而D不同的私钥须按D*G判定为不匹配
+	forged := &sm2.PrivateKey{PublicKey: priv.PublicKey, D: other.D}
+	if KeyMatchesCertificate(forged, cert) {
+		t.Error("key whose D does not match its public key accepted")
+	}
+	if KeyMatchesCertificate(priv, testdataCert(t, "ecdsa_p256.pem")) {
+		t.Error("ECDSA certificate accepted")
+	}
+}
+
+func TestValidateSM2(t *testing.T) {
+	priv, err := sm2.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if err := selfSignedSM2(t, priv, now.Add(-time.Hour), now.Add(time.Hour)).ValidateSM2(); err != nil {
+		t.Errorf("valid certificate: %v", err)
+	}
+	if err := testdataCert(t, "gm_sm2.pem").ValidateSM2(); err != nil {
+		t.Errorf("OpenSSL SM2 certificate: %v", err)
+	}
+	if err := selfSignedSM2(t, priv, now.Add(-2*time.Hour), now.Add(-time.Hour)).ValidateSM2(); err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Errorf("expired certificate: %v", err)
+	}
+	if err := selfSignedSM2(t, priv, now.Add(time.Hour), now.Add(2*time.Hour)).ValidateSM2(); err == nil || !strings.Contains(err.Error(), "not yet valid") {
+		t.Errorf("future certificate: %v", err)
+	}
+	if err := testdataCert(t, "ecdsa_p256.pem").ValidateSM2(); err == nil || !strings.Contains(err.Error(), "SM2 public key") {
+		t.Errorf("P-256 certificate: %v", err)
+	}
+	// SM2公钥但坐标不在SM2曲线上
+	offCurve := selfSignedSM2(t, priv, now.Add(-time.Hour), now.Add(time.Hour))
+	offCurve.PublicKey = &sm2.PublicKey{Curve: sm2.P256Sm2(), X: priv.X, Y: new(big.Int).Add(priv.Y, big.NewInt(1))}
+	if err := offCurve.ValidateSM2(); err == nil || !strings.Contains(err.Error(), "SM2 curve") {
+		t.Errorf("off-curve public key: %v", err)
+	}
+	wrongAlg := selfSignedSM2(t, priv, now.Add(-time.Hour), now.Add(time.Hour))
+	wrongAlg.SignatureAlgorithm = SM2WithSHA256
+	if err := wrongAlg.ValidateSM2(); err == nil || !strings.Contains(err.Error(), "SM2-SM3") {
+		t.Errorf("SM2-SHA256 signature: %v", err)
+	}
+}
@@ -1034,6 +1034,15 @@ func checkSignature(algo SignatureAlgorithm, signed, signature []byte, publicKey
 			}
 		}
 		return
+	case *sm2.PublicKey:
+		r, s, err := sm2.UnmarshalSignatureASN1(signature)
+		if err != nil {
+			return err
+		}
+		if !sm2.Sm2Verify(pub, signed, nil, r, s) {
+			return errors.New("x509: SM2 verification failure")
+		}
+		return nil
 	}
 	return ErrUnsupportedAlgorithm
 }
@@ -1044,6 +1053,64 @@ func (c *Certificate) CheckCRLSignature(crl *pkix.CertificateList) error {
 	return c.CheckSignature(algo, crl.TBSCertList.Raw, crl.SignatureValue.RightAlign())
 }
 
+// VerifyCRL checks that crl was issued and signed by issuer. It does not
+// check whether the CRL is current; see pkix.CertificateList.HasExpired.
+func VerifyCRL(crl *pkix.CertificateList, issuer *Certificate) error {
+	if crl == nil || issuer == nil {
+		return errors.New("x509: missing CRL or issuer certificate")
+	}
+	crlIssuer, err := crlRawIssuer(crl)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(crlIssuer, issuer.RawSubject) {
+		return errors.New("x509: CRL issuer does not match issuer certificate subject")
+	}
+	if issuer.KeyUsage != 0 && issuer.KeyUsage&KeyUsageCRLSign == 0 {
+		return ConstraintViolationError{}
+	}
+	return issuer.CheckCRLSignature(crl)
+}
+
+// crlRawIssuer returns the issuer Name of crl exactly as it was encoded.
+// Re-marshalling the parsed RDNSequence does not round-trip: a UTF8String
+// attribute would come back as a PrintableString.
+func crlRawIssuer(crl *pkix.CertificateList) ([]byte, error) {
+	var tbs asn1.RawValue
+	if _, err := asn1.Unmarshal(crl.TBSCertList.Raw, &tbs); err != nil {
+		return nil, err
+	}
+	// TBSCertList ::= SEQUENCE { version INTEGER OPTIONAL, signature, issuer, ... }
+	var elem asn1.RawValue
+	rest, err := asn1.Unmarshal(tbs.Bytes, &elem)
+	if err != nil {
+		return nil, err
+	}
+	if elem.Class == asn1.ClassUniversal && elem.Tag == asn1.TagInteger {
+		if rest, err = asn1.Unmarshal(rest, &elem); err != nil {
+			return nil, err
+		}
+	}
+	if _, err = asn1.Unmarshal(rest, &elem); err != nil {
+		return nil, err
+	}
+	if elem.Class != asn1.ClassUniversal || elem.Tag != asn1.TagSequence {
+		return nil, errors.New("x509: malformed CRL issuer")
+	}
+	return elem.FullBytes, nil
+}
+
+// IsRevoked reports whether crl lists the serial number of cert. The caller
+// is responsible for checking that crl belongs to cert's issuer.
+func IsRevoked(crl *pkix.CertificateList, cert *Certificate) bool {
+	for _, rc := range crl.TBSCertList.RevokedCertificates {
+		if rc.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
 type UnhandledCriticalExtension struct{}
 
 func (h UnhandledCriticalExtension) Error() string {
@@ -1171,17 +1238,19 @@ func parsePublicKey(algo PublicKeyAlgorithm, keyData *publicKeyInfo) (interface{
 		if x == nil {
 			return nil, errors.New("x509: failed to unmarshal elliptic curve point")
 		}
-		/*pub := &ecdsa.PublicKey{
-			Curve: namedCurve,
-			X:     x,
-			Y:     y,
-		}*/
-		pub := &sm2.PublicKey{
+		// SM2与NIST曲线共用id-ecPublicKey，只有曲线OID为SM2时才返回sm2公钥
+		if namedCurveOID.Equal(oidNamedCurveP256SM2) {
+			return &sm2.PublicKey{
+				Curve: namedCurve,
+				X:     x,
+				Y:     y,
+			}, nil
+		}
+		return &ecdsa.PublicKey{
 			Curve: namedCurve,
 			X:     x,
 			Y:     y,
-		}
-		return pub, nil
+		}, nil
 	default:
 		return nil, nil
 	}
@@ -1930,6 +1999,18 @@ func ParseDERCRL(derBytes []byte) (*pkix.CertificateList, error) {
 	return certList, nil
 }
 
+// tbsCertificateList is pkix.TBSCertificateList with the issuer kept as raw
+// DER, so CreateCRL can copy the issuer certificate's subject byte for byte.
+type tbsCertificateList struct {
+	Version             int `asn1:"optional,default:0"`
+	Signature           pkix.AlgorithmIdentifier
+	Issuer              asn1.RawValue
+	ThisUpdate          time.Time
+	NextUpdate          time.Time                 `asn1:"optional"`
+	RevokedCertificates []pkix.RevokedCertificate `asn1:"optional"`
+	Extensions          []pkix.Extension          `asn1:"tag:0,optional,explicit"`
+}
+
 // CreateCRL returns a DER encoded CRL, signed by this Certificate, that
 // contains the given list of revoked certificates.
 func (c *Certificate) CreateCRL(rand io.Reader, priv interface{}, revokedCerts []pkix.RevokedCertificate, now, expiry time.Time) (crlBytes []byte, err error) {
@@ -1950,10 +2031,14 @@ func (c *Certificate) CreateCRL(rand io.Reader, priv interface{}, revokedCerts [
 		revokedCertsUTC[i] = rc
 	}
 
-	tbsCertList := pkix.TBSCertificateList{
+	issuer, err := subjectBytes(c)
+	if err != nil {
+		return nil, err
+	}
+	tbsCertList := tbsCertificateList{
 		Version:             1,
 		Signature:           signatureAlgorithm,
-		Issuer:              c.Subject.ToRDNSequence(),
+		Issuer:              asn1.RawValue{FullBytes: issuer},
 		ThisUpdate:          now.UTC(),
 		NextUpdate:          expiry.UTC(),
 		RevokedCertificates: revokedCertsUTC,
@@ -1991,8 +2076,12 @@ func (c *Certificate) CreateCRL(rand io.Reader, priv interface{}, revokedCerts [
 		return
 	}
 
-	return asn1.Marshal(pkix.CertificateList{
-		TBSCertList:        tbsCertList,
+	return asn1.Marshal(struct {
+		TBSCertList        asn1.RawValue
+		SignatureAlgorithm pkix.AlgorithmIdentifier
+		SignatureValue     asn1.BitString
+	}{
+		TBSCertList:        asn1.RawValue{FullBytes: tbsCertListContents},
 		SignatureAlgorithm: signatureAlgorithm,
 		SignatureValue:     asn1.BitString{Bytes: signature, BitLength: len(signature) * 8},
 	})
@@ -2132,7 +2221,7 @@ func parseCSRExtensions(rawAttributes []asn1.RawValue) ([]pkix.Extension, error)
 // The returned slice is the certificate request in DER encoding.
 //
 // All keys types that are implemented via crypto.Signer are supported (This
-// includes *rsa.PublicKey and *ecdsa.PublicKey.)
+// includes *rsa.PublicKey, *ecdsa.PublicKey and *sm2.PublicKey.)
 func CreateCertificateRequest(rand io.Reader, template *CertificateRequest, signer crypto.Signer) (csr []byte, err error) {
 	var hashFunc Hash
 	var sigAlgo pkix.AlgorithmIdentifier
@@ -2256,8 +2345,8 @@ func CreateCertificateRequest(rand io.Reader, template *CertificateRequest, sign
 	tbsCSR.Raw = tbsCSRContents
 
 	digest := tbsCSRContents
-	switch template.SignatureAlgorithm {
-	case SM2WithSM3, SM2WithSHA1, SM2WithSHA256, UnknownSignatureAlgorithm:
+	switch getSignatureAlgorithmFromAI(sigAlgo) {
+	case SM2WithSM3, SM2WithSHA1, SM2WithSHA256:
 		break
 	default:
 		h := hashFunc.New()
@@ -2348,6 +2437,46 @@ func (c *CertificateRequest) CheckSignature() error {
 	return checkSignature(c.SignatureAlgorithm, c.RawTBSCertificateRequest, c.Signature, c.PublicKey)
 }
 
+// The SignatureAlgorithm and PublicKeyAlgorithm constants of this package
+// match crypto/x509 up to SHA512WithRSAPSS and ECDSA. Past that the values
+// diverge (crypto/x509 continues with the Ed25519 constants), so the SM2
+// values must not be converted numerically.
+
+func toStdSignatureAlgorithm(algo SignatureAlgorithm) x509.SignatureAlgorithm {
+	if algo <= SHA512WithRSAPSS {
+		return x509.SignatureAlgorithm(algo)
+	}
+	return x509.UnknownSignatureAlgorithm
+}
+
+func toStdPublicKeyAlgorithm(algo PublicKeyAlgorithm) x509.PublicKeyAlgorithm {
+	if algo <= ECDSA {
+		return x509.PublicKeyAlgorithm(algo)
+	}
+	return x509.UnknownPublicKeyAlgorithm
+}
+
+// fromStdSignatureAlgorithm maps the algorithm of x509Cert back to this
+// package. Algorithms crypto/x509 does not know, such as SM2-with-SM3, are
+// read from the signature AlgorithmIdentifier in x509Cert.Raw.
+func fromStdSignatureAlgorithm(x509Cert *x509.Certificate) SignatureAlgorithm {
+	if algo := x509Cert.SignatureAlgorithm; algo != x509.UnknownSignatureAlgorithm && algo <= x509.SHA512WithRSAPSS {
+		return SignatureAlgorithm(algo)
+	}
+	var cert certificate
+	if _, err := asn1.Unmarshal(x509Cert.Raw, &cert); err != nil {
+		return UnknownSignatureAlgorithm
+	}
+	return getSignatureAlgorithmFromAI(cert.SignatureAlgorithm)
+}
+
+func fromStdPublicKeyAlgorithm(x509Cert *x509.Certificate) PublicKeyAlgorithm {
+	if algo := x509Cert.PublicKeyAlgorithm; algo <= x509.ECDSA {
+		return PublicKeyAlgorithm(algo)
+	}
+	return UnknownPublicKeyAlgorithm
+}
+
 func (c *Certificate) ToX509Certificate() *x509.Certificate {
 	x509cert := &x509.Certificate{
 		Raw:                     c.Raw,
@@ -2357,9 +2486,9 @@ func (c *Certificate) ToX509Certificate() *x509.Certificate {
 		RawIssuer:               c.RawIssuer,
 
 		Signature:          c.Signature,
-		SignatureAlgorithm: x509.SignatureAlgorithm(c.SignatureAlgorithm),
+		SignatureAlgorithm: toStdSignatureAlgorithm(c.SignatureAlgorithm),
 
-		PublicKeyAlgorithm: x509.PublicKeyAlgorithm(c.PublicKeyAlgorithm),
+		PublicKeyAlgorithm: toStdPublicKeyAlgorithm(c.PublicKeyAlgorithm),
 		PublicKey:          c.PublicKey,
 
 		Version:      c.Version,
@@ -2424,8 +2553,8 @@ func (c *Certificate) FromX509Certificate(x509Cert *x509.Certificate) {
 	c.RawSubject = x509Cert.RawSubject
 	c.RawIssuer = x509Cert.RawIssuer
 	c.Signature = x509Cert.Signature
-	c.SignatureAlgorithm = SM2WithSM3
-	c.PublicKeyAlgorithm = PublicKeyAlgorithm(x509Cert.PublicKeyAlgorithm)
+	c.SignatureAlgorithm = fromStdSignatureAlgorithm(x509Cert)
+	c.PublicKeyAlgorithm = fromStdPublicKeyAlgorithm(x509Cert)
 	c.PublicKey = x509Cert.PublicKey
 	c.Version = x509Cert.Version
 	c.SerialNumber = x509Cert.SerialNumber
@@ -0,0 +1,209 @@
+package x509
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	stdx509 "crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"envelope/sm2"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// utf8Name 构造CN为UTF8String的Name，与OpenSSL及多数CA的默认编码一致
+func utf8Name(t *testing.T, cn string) []byte {
+	der, err := asn1.Marshal(pkix.RDNSequence{{{
+		Type:  asn1.ObjectIdentifier{2, 5, 4, 3},
+		Value: asn1.RawValue{Tag: asn1.TagUTF8String, Bytes: []byte(cn)},
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func TestVerifyCRLUTF8Issuer(t *testing.T) {
+	caKey, err := sm2.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	tmpl := &Certificate{
+		SerialNumber:          big.NewInt(1),
+		RawSubject:            utf8Name(t, "Test CA"),
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		KeyUsage:              KeyUsageCertSign | KeyUsageCRLSign,
+	}
+	der, err := CreateCertificate(tmpl, tmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leafKey, _ := sm2.GenerateKey(rand.Reader)
+	lt := &Certificate{SerialNumber: big.NewInt(42), Subject: pkix.Name{CommonName: "leaf"}, NotBefore: now.Add(-time.Hour), NotAfter: now.Add(time.Hour)}
+	ld, err := CreateCertificate(lt, ca, &leafKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := ParseCertificate(ld)
+	if err := leaf.CheckSignatureFrom(ca); err != nil {
+		t.Errorf("leaf does not verify against its CA: %v", err)
+	}
+	if err := ca.CheckSignatureFrom(leaf); err == nil {
+		t.Error("CA verified against a leaf that may not sign certificates")
+	}
+	// 主题相同但密钥不同的CA不能验证该叶子证书
+	otherKey, _ := sm2.GenerateKey(rand.Reader)
+	od, err := CreateCertificate(tmpl, tmpl, &otherKey.PublicKey, otherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _ := ParseCertificate(od)
+	if err := leaf.CheckSignatureFrom(other); err == nil {
+		t.Error("leaf verified against a CA with a different key")
+	}
+
+	crlDER, err := ca.CreateCRL(rand.Reader, caKey, []pkix.RevokedCertificate{{SerialNumber: big.NewInt(42), RevocationTime: now}}, now, now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	crl, err := ParseCRL(crlDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remarshalled, _ := asn1.Marshal(crl.TBSCertList.Issuer); bytes.Equal(remarshalled, ca.RawSubject) {
+		t.Fatal("test needs an issuer that does not survive re-marshalling")
+	}
+	if !crl.SignatureAlgorithm.Algorithm.Equal(oidSignatureSM2WithSM3) {
+		t.Errorf("CRL signature algorithm = %v", crl.SignatureAlgorithm.Algorithm)
+	}
+	if err := VerifyCRL(crl, ca); err != nil {
+		t.Fatal(err)
+	}
+	if !IsRevoked(crl, leaf) || IsRevoked(crl, ca) {
+		t.Error("IsRevoked does not match the revoked serial")
+	}
+	if err := VerifyCRL(crl, leaf); err == nil {
+		t.Error("CRL verified against a certificate that did not issue it")
+	}
+	crl.SignatureValue.Bytes[len(crl.SignatureValue.Bytes)-3] ^= 1
+	if err := VerifyCRL(crl, ca); err == nil {
+		t.Error("CRL with a corrupted signature verified")
+	}
+}
+
+// 模板未指定SignatureAlgorithm时SM2私钥应默认使用SM2-SM3签名
+func TestCreateSelfSignedSM2(t *testing.T) {
+	priv, err := sm2.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, alg := range []SignatureAlgorithm{UnknownSignatureAlgorithm, SM2WithSM3} {
+		tmpl := &Certificate{
+			SerialNumber:          big.NewInt(5),
+			Subject:               pkix.Name{CommonName: "self signed"},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(time.Hour),
+			SignatureAlgorithm:    alg,
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+		}
+		der, err := CreateCertificate(tmpl, tmpl, &priv.PublicKey, priv)
+		if err != nil {
+			t.Fatalf("%v: %v", alg, err)
+		}
+		cert, err := ParseCertificate(der)
+		if err != nil {
+			t.Fatalf("%v: %v", alg, err)
+		}
+		if cert.SignatureAlgorithm != SM2WithSM3 {
+			t.Errorf("%v: SignatureAlgorithm = %v, want SM2-SM3", alg, cert.SignatureAlgorithm)
+		}
+		if err := cert.CheckSignatureFrom(cert); err != nil {
+			t.Errorf("%v: %v", alg, err)
+		}
+	}
+}
+
+func TestCreateCertificateRequestSM2(t *testing.T) {
+	priv, err := sm2.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, alg := range []SignatureAlgorithm{UnknownSignatureAlgorithm, SM2WithSM3} {
+		tmpl := &CertificateRequest{Subject: pkix.Name{CommonName: "sm2 csr"}, SignatureAlgorithm: alg}
+		der, err := CreateCertificateRequest(rand.Reader, tmpl, priv)
+		if err != nil {
+			t.Fatalf("%v: %v", alg, err)
+		}
+		csr, err := ParseCertificateRequest(der)
+		if err != nil {
+			t.Fatalf("%v: %v", alg, err)
+		}
+		if csr.Subject.CommonName != "sm2 csr" || csr.SignatureAlgorithm != SM2WithSM3 {
+			t.Errorf("%v: subject %q, SignatureAlgorithm %v", alg, csr.Subject.CommonName, csr.SignatureAlgorithm)
+		}
+		if pub, ok := csr.PublicKey.(*sm2.PublicKey); !ok || pub.X.Cmp(priv.X) != 0 || pub.Y.Cmp(priv.Y) != 0 {
+			t.Fatalf("%v: public key %T does not match the signer", alg, csr.PublicKey)
+		}
+		if err := csr.CheckSignature(); err != nil {
+			t.Errorf("%v: %v", alg, err)
+		}
+		csr.Signature[len(csr.Signature)-3] ^= 1
+		if err := csr.CheckSignature(); err == nil {
+			t.Errorf("%v: CSR with a corrupted signature verified", alg)
+		}
+	}
+}
+
+// OpenSSL签发的SM2证书经crypto/x509往返后仍应报告SM2-SM3
+func TestFromX509CertificateSM2(t *testing.T) {
+	cert := testdataCert(t, "gm_sm2.pem")
+	if cert.SignatureAlgorithm != SM2WithSM3 || cert.SignatureAlgorithm.String() != "SM2-SM3" {
+		t.Fatalf("SignatureAlgorithm = %v, want SM2-SM3", cert.SignatureAlgorithm)
+	}
+	std := cert.ToX509Certificate()
+	if std.SignatureAlgorithm != stdx509.UnknownSignatureAlgorithm {
+		t.Errorf("crypto/x509 SignatureAlgorithm = %v, want unknown", std.SignatureAlgorithm)
+	}
+	var back Certificate
+	back.FromX509Certificate(std)
+	if back.SignatureAlgorithm != SM2WithSM3 || back.PublicKeyAlgorithm != cert.PublicKeyAlgorithm {
+		t.Errorf("round trip: SignatureAlgorithm %v, PublicKeyAlgorithm %v", back.SignatureAlgorithm, back.PublicKeyAlgorithm)
+	}
+
+	ec := testdataCert(t, "ecdsa_p256.pem")
+	var ecBack Certificate
+	ecBack.FromX509Certificate(ec.ToX509Certificate())
+	if ecBack.SignatureAlgorithm != ECDSAWithSHA256 {
+		t.Errorf("ECDSA round trip: SignatureAlgorithm = %v", ecBack.SignatureAlgorithm)
+	}
+}
+
+// 两者都是256位素数域曲线，只有SM2曲线OID应解析为*sm2.PublicKey
+func TestParseCertificateKeyType(t *testing.T) {
+	gm := testdataCert(t, "gm_sm2.pem")
+	if pub, ok := gm.PublicKey.(*sm2.PublicKey); !ok || pub.Curve != sm2.P256Sm2() {
+		t.Errorf("SM2 certificate: public key is %T", gm.PublicKey)
+	}
+	ec := testdataCert(t, "ecdsa_p256.pem")
+	if pub, ok := ec.PublicKey.(*ecdsa.PublicKey); !ok || pub.Curve != elliptic.P256() {
+		t.Errorf("P-256 certificate: public key is %T", ec.PublicKey)
+	}
+	for _, cert := range []*Certificate{gm, ec} {
+		if err := cert.CheckSignatureFrom(cert); err != nil {
+			t.Errorf("%s: %v", cert.Subject.CommonName, err)
+		}
+	}
+}